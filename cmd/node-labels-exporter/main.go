@@ -26,6 +26,8 @@ import (
 	flag "github.com/spf13/pflag"
 	"go.uber.org/zap/zapcore"
 
+	"github.com/sergelogvinov/node-labels-exporter/pkg/apis/nodelabels/v1alpha1"
+	exporterconfig "github.com/sergelogvinov/node-labels-exporter/pkg/config"
 	"github.com/sergelogvinov/node-labels-exporter/pkg/nodelabelcontroller"
 
 	corev1 "k8s.io/api/core/v1"
@@ -35,10 +37,13 @@ import (
 	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -58,19 +63,24 @@ var (
 	certDir = flag.String("cert-dir", "certs", "webhook certificate directory")
 	port    = flag.Int("port", 9443, "The port to which the admission webhook endpoint should bind")
 
+	configFile = flag.String("config", "", "Path to the configuration file, it is reloaded on change.")
+
+	enableExportPolicies = flag.Bool("enable-export-policies", false, "Enable the NodeLabelExportPolicy and NamespacedNodeLabelExportPolicy resources, the CRDs must be installed.")
+
 	metricsEndpoint = flag.String("metrics-endpoint", ":8080", "The TCP network address where the HTTPS server for diagnostics, including pprof, metrics will listen (example: `:8080`).")
 
 	scheme = runtime.NewScheme()
 )
 
 const (
-	// ResyncPeriodOfNodeInformer is the resync period of the informer for the Node objects
+	// ResyncPeriodOfNodeInformer is the default resync period of the informer for the Node and Namespace objects
 	ResyncPeriodOfNodeInformer = 1 * time.Hour
 )
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(corev1.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
 }
 
 func main() {
@@ -99,6 +109,16 @@ func main() {
 		os.Exit(0)
 	}
 
+	cfg := &exporterconfig.Config{}
+
+	if *configFile != "" {
+		cfg, err = exporterconfig.ReadConfigFile(*configFile)
+		if err != nil {
+			log.Error(err, "Failed to read config file", "config", *configFile)
+			os.Exit(1)
+		}
+	}
+
 	// get the KUBECONFIG from env if specified (useful for local/debug cluster)
 	kubeconfigEnv := os.Getenv("KUBECONFIG")
 
@@ -154,12 +174,60 @@ func main() {
 
 	ctrl.SetLogger(log)
 
-	factory := informers.NewSharedInformerFactory(clientset, ResyncPeriodOfNodeInformer)
-	nodeLister := factory.Core().V1().Nodes().Lister()
+	resyncPeriod := ResyncPeriodOfNodeInformer
+	if cfg.InformerResyncPeriod.Duration > 0 {
+		resyncPeriod = cfg.InformerResyncPeriod.Duration
+	}
+
+	factory := informers.NewSharedInformerFactory(clientset, resyncPeriod)
+	nodeInformer := factory.Core().V1().Nodes()
+	nodeLister := nodeInformer.Lister()
+	namespaceLister := factory.Core().V1().Namespaces().Lister()
 
 	log.Info("Starting Node Labels exporter")
 
-	m := nodelabelcontroller.NewNodeLabelsEnvInjector(clientset, scheme, nodeLister, ctrl.Log.WithName("controllers").WithName("NodeLabelsEnvInjector"))
+	var policies client.Client
+	if *enableExportPolicies {
+		policies = mgr.GetClient()
+	}
+
+	m := nodelabelcontroller.NewNodeLabelsEnvInjector(clientset, scheme, nodeLister, namespaceLister, policies, cfg,
+		mgr.GetEventRecorderFor("node-labels-exporter"), version, ctrl.Log.WithName("controllers").WithName("NodeLabelsEnvInjector"))
+
+	if err := mgr.Add(manager.RunnableFunc(m.RunPolicyStatusUpdater)); err != nil { //nolint: noinlineerr
+		log.Error(err, "unable to add policy status updater")
+		os.Exit(1)
+	}
+
+	if err := mgr.Add(manager.RunnableFunc(m.RunPatchQueue)); err != nil { //nolint: noinlineerr
+		log.Error(err, "unable to add pod patch retry queue")
+		os.Exit(1)
+	}
+
+	if *configFile != "" {
+		watcher, err := exporterconfig.NewWatcher(*configFile)
+		if err != nil {
+			log.Error(err, "Failed to watch config file", "config", *configFile)
+			os.Exit(1)
+		}
+
+		watcher.OnChange = func(c *exporterconfig.Config) {
+			if c.InformerResyncPeriod != cfg.InformerResyncPeriod {
+				log.Info("Informer resync period change requires a restart", "config", *configFile)
+			}
+
+			log.Info("Reloaded config file", "config", *configFile)
+			m.SetConfig(c)
+		}
+		watcher.OnError = func(err error) {
+			log.Error(err, "Failed to reload config file, keeping the previous configuration", "config", *configFile)
+		}
+
+		if err := mgr.Add(manager.RunnableFunc(watcher.Start)); err != nil { //nolint: noinlineerr
+			log.Error(err, "unable to add config file watcher")
+			os.Exit(1)
+		}
+	}
 
 	mgr.GetWebhookServer().Register("/webhook", &webhook.Admission{
 		Handler: admission.HandlerFunc(m.Handle),
@@ -172,12 +240,10 @@ func main() {
 
 		factory.Start(ctx.Done())
 
-		cacheSyncResult := factory.WaitForCacheSync(ctx.Done())
-		for _, v := range cacheSyncResult {
-			if !v {
-				log.Info("Failed to sync Informers!")
-				os.Exit(1)
-			}
+		// The namespace informer does not block the start, the namespace annotations are applied once it is synced
+		if !cache.WaitForCacheSync(ctx.Done(), nodeInformer.Informer().HasSynced) {
+			log.Info("Failed to sync Informers!")
+			os.Exit(1)
 		}
 
 		if err := mgr.Start(ctx); err != nil { //nolint: noinlineerr
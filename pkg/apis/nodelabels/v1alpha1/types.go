@@ -0,0 +1,137 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ExportSink is the destination of the exported node labels
+// +kubebuilder:validation:Enum=Env;Label;JSON
+type ExportSink string
+
+const (
+	// ExportSinkEnv exports the node label as a pod label and an environment variable referencing it
+	ExportSinkEnv ExportSink = "Env"
+	// ExportSinkLabel exports the node label as a pod label only
+	ExportSinkLabel ExportSink = "Label"
+	// ExportSinkJSON exports the node label in the JSON document environment variable
+	ExportSinkJSON ExportSink = "JSON"
+)
+
+const (
+	// ConditionReady reports whether the policy spec is valid
+	ConditionReady = "Ready"
+)
+
+// NodeLabelExport describes a node label exported to the pods
+type NodeLabelExport struct {
+	// Keys is the ordered list of candidate node label keys, the first one present on the node wins.
+	// A single key ending with `*` is a prefix selector.
+	// +kubebuilder:validation:MinItems=1
+	Keys []string `json:"keys"`
+
+	// Env is the environment variable name. The export policies normalize it the same way as the injector annotations,
+	// the structured pod configuration annotation uses it as is.
+	// Required for the Env sink, for the JSON sink it overrides the JSON document environment variable name.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z_][A-Za-z0-9_-]*$`
+	// +optional
+	Env string `json:"env,omitempty"`
+
+	// Sink is the destination of the node label, defaults to Env.
+	// +optional
+	Sink ExportSink `json:"sink,omitempty"`
+}
+
+// NodeLabelExportPolicySpec defines the node labels exported to the selected pods
+type NodeLabelExportPolicySpec struct {
+	// NamespaceSelector selects the namespaces of the pods, empty selector matches all namespaces.
+	// It is ignored by the NamespacedNodeLabelExportPolicy.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// PodSelector selects the pods, empty selector matches all pods.
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	// Containers is the list of target container names, empty list means all containers.
+	// +optional
+	Containers []string `json:"containers,omitempty"`
+
+	// Exports is the list of exported node labels.
+	// +kubebuilder:validation:MinItems=1
+	Exports []NodeLabelExport `json:"exports"`
+}
+
+// NodeLabelExportPolicyStatus defines the observed state of the policy
+type NodeLabelExportPolicyStatus struct {
+	// MatchedPods is the number of created pods matched by the policy.
+	// +optional
+	MatchedPods int64 `json:"matchedPods,omitempty"`
+
+	// Conditions is the list of the policy conditions.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// NodeLabelExportPolicy is the cluster-wide node labels export policy
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,shortName=nlep
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Matched",type=integer,JSONPath=`.status.matchedPods`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type NodeLabelExportPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NodeLabelExportPolicySpec   `json:"spec"`
+	Status NodeLabelExportPolicyStatus `json:"status,omitempty"`
+}
+
+// NodeLabelExportPolicyList contains a list of NodeLabelExportPolicy
+// +kubebuilder:object:root=true
+type NodeLabelExportPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []NodeLabelExportPolicy `json:"items"`
+}
+
+// NamespacedNodeLabelExportPolicy is the node labels export policy for the pods in its namespace
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Namespaced,shortName=nnlep
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Matched",type=integer,JSONPath=`.status.matchedPods`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type NamespacedNodeLabelExportPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NodeLabelExportPolicySpec   `json:"spec"`
+	Status NodeLabelExportPolicyStatus `json:"status,omitempty"`
+}
+
+// NamespacedNodeLabelExportPolicyList contains a list of NamespacedNodeLabelExportPolicy
+// +kubebuilder:object:root=true
+type NamespacedNodeLabelExportPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []NamespacedNodeLabelExportPolicy `json:"items"`
+}
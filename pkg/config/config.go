@@ -0,0 +1,396 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config contains the Node Labels exporter configuration
+package config
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/sergelogvinov/node-labels-exporter/pkg/apis/nodelabels/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"sigs.k8s.io/yaml"
+)
+
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+const (
+	// APIVersion is the current version of the configuration schema
+	APIVersion = "node-labels-exporter.sinextra.dev/v1alpha1"
+	// Kind is the kind of the configuration
+	Kind = "Configuration"
+)
+
+// Config is the Node Labels exporter configuration
+type Config struct {
+	// APIVersion is the version of the configuration schema, empty means the current version
+	APIVersion string `json:"apiVersion,omitempty"`
+	// Kind is the kind of the configuration, empty or Configuration
+	Kind string `json:"kind,omitempty"`
+
+	// AnnotationDomain is the domain of the exporter annotations, defaults to node-labels-exporter.sinextra.dev
+	AnnotationDomain string `json:"annotationDomain,omitempty"`
+	// DefaultContainers is the list of target container names for pods without the containers annotation,
+	// empty list means all containers
+	DefaultContainers []string `json:"defaultContainers,omitempty"`
+	// EnvPrefix is the prefix of all environment variable names, the pod annotation overrides it
+	EnvPrefix string `json:"envPrefix,omitempty"`
+	// EnvConflictPolicy is the action for the environment variables already defined in the containers, the pod annotation overrides it
+	EnvConflictPolicy EnvConflictPolicy `json:"envConflictPolicy,omitempty"`
+	// LabelConflictPolicy is the action for the pod labels already set and not owned by the exporter, the pod annotation overrides it
+	LabelConflictPolicy LabelConflictPolicy `json:"labelConflictPolicy,omitempty"`
+	// EnvPlacement is the position of the injected environment variables in the container, the pod annotation overrides it
+	EnvPlacement EnvPlacement `json:"envPlacement,omitempty"`
+	// PodLabelPrefix is the pod label key prefix ending with "/", it replaces the node label key prefix,
+	// e.g. node.sinextra.dev/ copies topology.kubernetes.io/zone to node.sinextra.dev/zone. The pod annotation overrides it.
+	PodLabelPrefix string `json:"podLabelPrefix,omitempty"`
+	// PodLabels is the map of node label key to pod label key, it wins over the prefix
+	PodLabels map[string]string `json:"podLabels,omitempty"`
+	// Sinks is the list of enabled export sinks, empty list enables all sinks
+	Sinks []v1alpha1.ExportSink `json:"sinks,omitempty"`
+	// InformerResyncPeriod is the resync period of the Node and Namespace informers, changes require a restart
+	InformerResyncPeriod metav1.Duration `json:"informerResyncPeriod,omitempty"`
+
+	// Profiles is the map of named export profiles, pods reference them by name
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+	// Labels restricts the node labels pods may export
+	Labels LabelPolicy `json:"labels,omitempty"`
+}
+
+// EnvConflictPolicy is the action for the environment variables already defined in the containers
+type EnvConflictPolicy string
+
+const (
+	// EnvConflictOverwrite replaces the container environment variable, it is the default
+	EnvConflictOverwrite EnvConflictPolicy = "Overwrite"
+	// EnvConflictSkip keeps the container environment variable
+	EnvConflictSkip EnvConflictPolicy = "Skip"
+	// EnvConflictFail denies the pod creation
+	EnvConflictFail EnvConflictPolicy = "Fail"
+)
+
+// IsValid reports whether the policy is known
+func (p EnvConflictPolicy) IsValid() bool {
+	switch p {
+	case EnvConflictOverwrite, EnvConflictSkip, EnvConflictFail:
+		return true
+	}
+
+	return false
+}
+
+// EnvPlacement is the position of the injected environment variables in the container,
+// the injected environment variables are sorted by name
+type EnvPlacement string
+
+const (
+	// EnvPlacementAuto places the environment variables before the first container environment variable
+	// referencing them as $(VAR), the others are appended. It is the default.
+	EnvPlacementAuto EnvPlacement = "Auto"
+	// EnvPlacementAppend appends the environment variables after the container ones
+	EnvPlacementAppend EnvPlacement = "Append"
+	// EnvPlacementPrepend places the environment variables before the container ones
+	EnvPlacementPrepend EnvPlacement = "Prepend"
+)
+
+// IsValid reports whether the placement is known
+func (p EnvPlacement) IsValid() bool {
+	switch p {
+	case EnvPlacementAuto, EnvPlacementAppend, EnvPlacementPrepend:
+		return true
+	}
+
+	return false
+}
+
+// LabelConflictPolicy is the action for the pod labels already set and not owned by the exporter
+type LabelConflictPolicy string
+
+const (
+	// LabelConflictOverwrite replaces the pod label and takes the ownership of it, it is the default
+	LabelConflictOverwrite LabelConflictPolicy = "Overwrite"
+	// LabelConflictKeep keeps the pod label
+	LabelConflictKeep LabelConflictPolicy = "Keep"
+	// LabelConflictFail denies the pod creation, the pod label is kept at bind time
+	LabelConflictFail LabelConflictPolicy = "Fail"
+)
+
+// IsValid reports whether the policy is known
+func (p LabelConflictPolicy) IsValid() bool {
+	switch p {
+	case LabelConflictOverwrite, LabelConflictKeep, LabelConflictFail:
+		return true
+	}
+
+	return false
+}
+
+// LabelPolicy is the cluster-wide node label filter with optional per namespace filters
+//
+//	allow: ["topology.kubernetes.io/*", "kubernetes.io/hostname"]
+//	deny: ["topology.kubernetes.io/rack"]
+//	namespaces:
+//	  tenant-a:
+//	    deny: ["topology.kubernetes.io/*"]
+type LabelPolicy struct {
+	LabelFilter
+
+	// Namespaces is the map of namespace name to the filter applied in addition to the cluster-wide one
+	Namespaces map[string]LabelFilter `json:"namespaces,omitempty"`
+}
+
+// LabelFilter is the allowlist and denylist of node label keys,
+// the entries are exact label keys or prefixes ending with "*"
+type LabelFilter struct {
+	// Allow is the list of node labels pods may export, empty list allows all labels
+	Allow []string `json:"allow,omitempty"`
+	// Deny is the list of node labels pods may not export, it wins over the allowlist
+	Deny []string `json:"deny,omitempty"`
+}
+
+// Profile is a named set of exports, the key is the environment variable name
+// and the value is the node label key, it has the same format as the injector annotations
+//
+//	topology:
+//	  region: topology.kubernetes.io/region
+//	  zone: topology.kubernetes.io/zone,failure-domain.beta.kubernetes.io/zone
+type Profile map[string]string
+
+// ReadConfigFile reads the configuration from the file
+func ReadConfigFile(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer f.Close() //nolint: errcheck
+
+	return ReadConfig(f)
+}
+
+// ReadConfig reads the configuration from the reader
+func ReadConfig(r io.Reader) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil { //nolint: noinlineerr
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	if err := cfg.Validate(); err != nil { //nolint: noinlineerr
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate validates the configuration
+func (c *Config) Validate() error {
+	if c.APIVersion != "" && c.APIVersion != APIVersion {
+		return fmt.Errorf("unsupported apiVersion %q, expected %q", c.APIVersion, APIVersion)
+	}
+
+	if c.Kind != "" && c.Kind != Kind {
+		return fmt.Errorf("unsupported kind %q, expected %q", c.Kind, Kind)
+	}
+
+	if c.AnnotationDomain != "" {
+		if errs := validation.IsDNS1123Subdomain(c.AnnotationDomain); len(errs) > 0 {
+			return fmt.Errorf("annotationDomain: %s", strings.Join(errs, ", "))
+		}
+	}
+
+	if slices.ContainsFunc(c.DefaultContainers, func(name string) bool { return strings.TrimSpace(name) == "" }) {
+		return fmt.Errorf("defaultContainers: container name is empty")
+	}
+
+	if c.EnvPrefix != "" && !envNameRegexp.MatchString(c.EnvPrefix) {
+		return fmt.Errorf("envPrefix: %q must consist of letters, digits and '_' and must not start with a digit", c.EnvPrefix)
+	}
+
+	if c.EnvConflictPolicy != "" && !c.EnvConflictPolicy.IsValid() {
+		return fmt.Errorf("envConflictPolicy: unknown policy %q", c.EnvConflictPolicy)
+	}
+
+	if c.LabelConflictPolicy != "" && !c.LabelConflictPolicy.IsValid() {
+		return fmt.Errorf("labelConflictPolicy: unknown policy %q", c.LabelConflictPolicy)
+	}
+
+	if c.EnvPlacement != "" && !c.EnvPlacement.IsValid() {
+		return fmt.Errorf("envPlacement: unknown placement %q", c.EnvPlacement)
+	}
+
+	if c.PodLabelPrefix != "" {
+		if err := ValidatePodLabelPrefix(c.PodLabelPrefix); err != nil { //nolint: noinlineerr
+			return fmt.Errorf("podLabelPrefix: %v", err)
+		}
+	}
+
+	for key, label := range c.PodLabels {
+		if errs := validation.IsQualifiedName(label); len(errs) > 0 {
+			return fmt.Errorf("podLabels[%s]: %s", key, strings.Join(errs, ", "))
+		}
+	}
+
+	for _, sink := range c.Sinks {
+		switch sink {
+		case v1alpha1.ExportSinkEnv, v1alpha1.ExportSinkLabel, v1alpha1.ExportSinkJSON:
+		default:
+			return fmt.Errorf("sinks: unknown sink %q", sink)
+		}
+	}
+
+	if c.InformerResyncPeriod.Duration < 0 {
+		return fmt.Errorf("informerResyncPeriod: must not be negative")
+	}
+
+	for name, profile := range c.Profiles {
+		if len(profile) == 0 {
+			return fmt.Errorf("profile %q has no exports", name)
+		}
+
+		if err := profile.validate(); err != nil { //nolint: noinlineerr
+			return fmt.Errorf("profile %q: %v", name, err)
+		}
+	}
+
+	if err := c.Labels.validate(); err != nil { //nolint: noinlineerr
+		return fmt.Errorf("labels: %v", err)
+	}
+
+	for name, filter := range c.Labels.Namespaces {
+		if err := filter.validate(); err != nil { //nolint: noinlineerr
+			return fmt.Errorf("labels.namespaces[%s]: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// ValidatePodLabelPrefix validates the pod label key prefix, it is a DNS subdomain followed by "/"
+func ValidatePodLabelPrefix(prefix string) error {
+	domain, ok := strings.CutSuffix(prefix, "/")
+	if !ok {
+		return fmt.Errorf("%q must end with '/'", prefix)
+	}
+
+	if errs := validation.IsDNS1123Subdomain(domain); len(errs) > 0 {
+		return fmt.Errorf("%q: %s", prefix, strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// PodLabelKey returns the pod label key of the node label key, the mapping wins over the prefix
+func PodLabelKey(key string, mapping map[string]string, prefix string) string {
+	if label, ok := mapping[key]; ok {
+		return label
+	}
+
+	if prefix != "" {
+		return prefix + key[strings.LastIndex(key, "/")+1:]
+	}
+
+	return key
+}
+
+// SinkEnabled reports whether the export sink is enabled
+func (c *Config) SinkEnabled(sink v1alpha1.ExportSink) bool {
+	return len(c.Sinks) == 0 || slices.Contains(c.Sinks, sink)
+}
+
+// Allowed reports whether pods in the namespace may export the node label
+func (p LabelPolicy) Allowed(namespace, key string) bool {
+	if !p.LabelFilter.Allowed(key) {
+		return false
+	}
+
+	if filter, ok := p.Namespaces[namespace]; ok {
+		return filter.Allowed(key)
+	}
+
+	return true
+}
+
+// IsEmpty reports whether the policy allows all node labels
+func (p LabelPolicy) IsEmpty() bool {
+	return p.LabelFilter.IsEmpty() && len(p.Namespaces) == 0
+}
+
+// Allowed reports whether the node label passes the filter
+func (f LabelFilter) Allowed(key string) bool {
+	if slices.ContainsFunc(f.Deny, func(pattern string) bool { return matchLabelKey(pattern, key) }) {
+		return false
+	}
+
+	return len(f.Allow) == 0 || slices.ContainsFunc(f.Allow, func(pattern string) bool { return matchLabelKey(pattern, key) })
+}
+
+// IsEmpty reports whether the filter allows all node labels
+func (f LabelFilter) IsEmpty() bool {
+	return len(f.Allow) == 0 && len(f.Deny) == 0
+}
+
+// validate returns an error for the export with an invalid environment variable name or without node label keys,
+// the key is normalized the same way as the injector annotation key
+func (p Profile) validate() error {
+	for _, key := range slices.Sorted(maps.Keys(p)) {
+		env, value := strings.ReplaceAll(strings.ToUpper(key), "-", "_"), p[key]
+		if name, keys, ok := strings.Cut(value, "="); ok {
+			env, value = strings.TrimSpace(name), keys
+		}
+
+		if !envNameRegexp.MatchString(env) {
+			return fmt.Errorf("%s: environment variable name %q must consist of letters, digits and '_' and must not start with a digit", key, env)
+		}
+
+		if strings.Trim(value, ", ") == "" {
+			return fmt.Errorf("%s: node label keys are empty", key)
+		}
+	}
+
+	return nil
+}
+
+func (f LabelFilter) validate() error {
+	for _, pattern := range slices.Concat(f.Allow, f.Deny) {
+		if strings.TrimSpace(pattern) == "" {
+			return fmt.Errorf("label key is empty")
+		}
+	}
+
+	return nil
+}
+
+// matchLabelKey reports whether the label key matches the exact key or the prefix ending with "*"
+func matchLabelKey(pattern, key string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(key, prefix)
+	}
+
+	return pattern == key
+}
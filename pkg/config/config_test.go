@@ -0,0 +1,269 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sergelogvinov/node-labels-exporter/pkg/apis/nodelabels/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReadConfig(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		config   string
+		expected *Config
+		err      string
+	}{
+		{
+			name:     "empty config",
+			config:   "",
+			expected: &Config{},
+		},
+		{
+			name: "profiles",
+			config: `
+profiles:
+  topology:
+    region: topology.kubernetes.io/region
+    zone: topology.kubernetes.io/zone,failure-domain.beta.kubernetes.io/zone
+`,
+			expected: &Config{
+				Profiles: map[string]Profile{
+					"topology": {
+						"region": "topology.kubernetes.io/region",
+						"zone":   "topology.kubernetes.io/zone,failure-domain.beta.kubernetes.io/zone",
+					},
+				},
+			},
+		},
+		{
+			name: "empty profile",
+			config: `
+profiles:
+  topology: {}
+`,
+			err: `profile "topology" has no exports`,
+		},
+		{
+			name: "profile with invalid env name",
+			config: `
+profiles:
+  topology:
+    node.zone: topology.kubernetes.io/zone
+`,
+			err: `profile "topology": node.zone: environment variable name "NODE.ZONE" must consist of letters, digits and '_' and must not start with a digit`,
+		},
+		{
+			name: "profile with invalid explicit env name",
+			config: `
+profiles:
+  topology:
+    zone: 1ZONE=topology.kubernetes.io/zone
+`,
+			err: `profile "topology": zone: environment variable name "1ZONE" must consist of letters, digits and '_' and must not start with a digit`,
+		},
+		{
+			name: "profile without label keys",
+			config: `
+profiles:
+  topology:
+    zone: ""
+`,
+			err: `profile "topology": zone: node label keys are empty`,
+		},
+		{
+			name: "labels",
+			config: `
+labels:
+  allow: ["topology.kubernetes.io/*"]
+  deny: ["topology.kubernetes.io/rack"]
+  namespaces:
+    tenant-a:
+      deny: ["topology.kubernetes.io/*"]
+`,
+			expected: &Config{
+				Labels: LabelPolicy{
+					LabelFilter: LabelFilter{
+						Allow: []string{"topology.kubernetes.io/*"},
+						Deny:  []string{"topology.kubernetes.io/rack"},
+					},
+					Namespaces: map[string]LabelFilter{
+						"tenant-a": {Deny: []string{"topology.kubernetes.io/*"}},
+					},
+				},
+			},
+		},
+		{
+			name: "empty namespace label key",
+			config: `
+labels:
+  namespaces:
+    tenant-a:
+      allow: [""]
+`,
+			err: "labels.namespaces[tenant-a]: label key is empty",
+		},
+		{
+			name: "versioned config",
+			config: `
+apiVersion: node-labels-exporter.sinextra.dev/v1alpha1
+kind: Configuration
+annotationDomain: platform.example.com
+envConflictPolicy: Skip
+labelConflictPolicy: Keep
+envPlacement: Prepend
+podLabelPrefix: node.sinextra.dev/
+podLabels:
+  topology.kubernetes.io/zone: zone
+defaultContainers: [app]
+sinks: [Env, JSON]
+informerResyncPeriod: 30m
+`,
+			expected: &Config{
+				APIVersion:           APIVersion,
+				Kind:                 Kind,
+				AnnotationDomain:     "platform.example.com",
+				EnvConflictPolicy:    EnvConflictSkip,
+				LabelConflictPolicy:  LabelConflictKeep,
+				EnvPlacement:         EnvPlacementPrepend,
+				PodLabelPrefix:       "node.sinextra.dev/",
+				PodLabels:            map[string]string{"topology.kubernetes.io/zone": "zone"},
+				DefaultContainers:    []string{"app"},
+				Sinks:                []v1alpha1.ExportSink{v1alpha1.ExportSinkEnv, v1alpha1.ExportSinkJSON},
+				InformerResyncPeriod: metav1.Duration{Duration: 30 * time.Minute},
+			},
+		},
+		{
+			name:   "unsupported version",
+			config: "apiVersion: node-labels-exporter.sinextra.dev/v2\n",
+			err:    `unsupported apiVersion "node-labels-exporter.sinextra.dev/v2"`,
+		},
+		{
+			name:   "invalid annotation domain",
+			config: "annotationDomain: Example.com/\n",
+			err:    "annotationDomain:",
+		},
+		{
+			name:   "invalid env prefix",
+			config: "envPrefix: NLE-\n",
+			err:    `envPrefix: "NLE-" must consist of letters, digits and '_'`,
+		},
+		{
+			name:   "unknown env conflict policy",
+			config: "envConflictPolicy: Merge\n",
+			err:    `envConflictPolicy: unknown policy "Merge"`,
+		},
+		{
+			name:   "unknown label conflict policy",
+			config: "labelConflictPolicy: Skip\n",
+			err:    `labelConflictPolicy: unknown policy "Skip"`,
+		},
+		{
+			name:   "unknown env placement",
+			config: "envPlacement: Sorted\n",
+			err:    `envPlacement: unknown placement "Sorted"`,
+		},
+		{
+			name:   "invalid pod label prefix",
+			config: "podLabelPrefix: node.sinextra.dev\n",
+			err:    `podLabelPrefix: "node.sinextra.dev" must end with '/'`,
+		},
+		{
+			name:   "invalid pod label",
+			config: "podLabels:\n  topology.kubernetes.io/zone: zone/a/b\n",
+			err:    "podLabels[topology.kubernetes.io/zone]:",
+		},
+		{
+			name:   "unknown sink",
+			config: "sinks: [File]\n",
+			err:    `sinks: unknown sink "File"`,
+		},
+		{
+			name:   "negative resync period",
+			config: "informerResyncPeriod: -1m\n",
+			err:    "informerResyncPeriod: must not be negative",
+		},
+		{
+			name: "unknown field",
+			config: `
+profile:
+  topology: {}
+`,
+			err: "failed to parse config",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ReadConfig(strings.NewReader(tt.config))
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, cfg)
+		})
+	}
+}
+
+func TestLabelPolicyAllowed(t *testing.T) {
+	policy := LabelPolicy{
+		LabelFilter: LabelFilter{
+			Allow: []string{"topology.kubernetes.io/*", "kubernetes.io/hostname"},
+			Deny:  []string{"topology.kubernetes.io/rack"},
+		},
+		Namespaces: map[string]LabelFilter{
+			"tenant-a": {Deny: []string{"kubernetes.io/hostname"}},
+			"tenant-b": {Allow: []string{"topology.kubernetes.io/region"}},
+		},
+	}
+
+	for _, tt := range []struct {
+		namespace string
+		key       string
+		expected  bool
+	}{
+		{namespace: "default", key: "topology.kubernetes.io/zone", expected: true},
+		{namespace: "default", key: "topology.kubernetes.io/rack", expected: false},
+		{namespace: "default", key: "kubernetes.io/hostname", expected: true},
+		{namespace: "default", key: "sinextra.dev/tenant", expected: false},
+		{namespace: "tenant-a", key: "kubernetes.io/hostname", expected: false},
+		{namespace: "tenant-a", key: "topology.kubernetes.io/zone", expected: true},
+		{namespace: "tenant-b", key: "topology.kubernetes.io/region", expected: true},
+		{namespace: "tenant-b", key: "topology.kubernetes.io/zone", expected: false},
+	} {
+		t.Run(tt.namespace+"/"+tt.key, func(t *testing.T) {
+			assert.Equal(t, tt.expected, policy.Allowed(tt.namespace, tt.key))
+		})
+	}
+}
+
+func TestPodLabelKey(t *testing.T) {
+	mapping := map[string]string{"topology.kubernetes.io/zone": "sinextra.dev/zone"}
+
+	assert.Equal(t, "sinextra.dev/zone", PodLabelKey("topology.kubernetes.io/zone", mapping, "node.sinextra.dev/"))
+	assert.Equal(t, "node.sinextra.dev/region", PodLabelKey("topology.kubernetes.io/region", mapping, "node.sinextra.dev/"))
+	assert.Equal(t, "node.sinextra.dev/pool", PodLabelKey("pool", mapping, "node.sinextra.dev/"))
+	assert.Equal(t, "topology.kubernetes.io/region", PodLabelKey("topology.kubernetes.io/region", mapping, ""))
+}
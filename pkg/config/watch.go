@@ -0,0 +1,140 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// watchDebounce is the delay of the reload after the last configuration file event
+	watchDebounce = 250 * time.Millisecond
+	// configMapDataDir is the symlink swapped by the kubelet on the ConfigMap volume update
+	configMapDataDir = "..data"
+)
+
+// Watcher reloads the configuration file on change
+type Watcher struct {
+	path string
+	// data is the content of the configuration file in use
+	data []byte
+	// debounce is the delay of the reload after the last configuration file event
+	debounce time.Duration
+
+	// OnChange is called with the new valid configuration
+	OnChange func(cfg *Config)
+	// OnError is called if the changed configuration can not be read, the previous configuration stays in use
+	OnError func(err error)
+}
+
+// NewWatcher creates a new configuration file watcher, the file is expected to be already read
+func NewWatcher(path string) (*Watcher, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	return &Watcher{path: path, data: data, debounce: watchDebounce}, nil
+}
+
+// Start watches the configuration file until the context is done.
+// The parent directory is watched, so the file replacement by the ConfigMap volume symlink swap is noticed.
+// The events of the configuration file and the ConfigMap data symlink are debounced, the other files are ignored.
+func (w *Watcher) Start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config file watcher: %v", err)
+	}
+	defer watcher.Close() //nolint: errcheck
+
+	if err := watcher.Add(filepath.Dir(w.path)); err != nil { //nolint: noinlineerr
+		return fmt.Errorf("failed to watch config file: %v", err)
+	}
+
+	var reload <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			if name := filepath.Base(event.Name); name == filepath.Base(w.path) || name == configMapDataDir {
+				reload = time.After(w.debounce)
+			}
+		case <-reload:
+			reload = nil
+
+			w.reload()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+
+			w.notifyError(fmt.Errorf("config file watcher: %v", err))
+		}
+	}
+}
+
+func (w *Watcher) reload() {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		w.notifyError(fmt.Errorf("failed to read config file: %v", err))
+
+		return
+	}
+
+	if bytes.Equal(data, w.data) {
+		return
+	}
+
+	// The in-place write truncates the file first, the empty configuration would clear the label policy
+	if len(bytes.TrimSpace(data)) == 0 {
+		w.notifyError(fmt.Errorf("config file is empty"))
+
+		return
+	}
+
+	cfg, err := ReadConfig(bytes.NewReader(data))
+	if err != nil {
+		w.notifyError(err)
+
+		return
+	}
+
+	w.data = data
+
+	if w.OnChange != nil {
+		w.OnChange(cfg)
+	}
+}
+
+func (w *Watcher) notifyError(err error) {
+	if w.OnError != nil {
+		w.OnError(err)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("defaultContainers: [app]\n"), 0o600))
+
+	w, err := NewWatcher(path)
+	assert.NoError(t, err)
+
+	configs := make(chan *Config, 1)
+	errs := make(chan error, 1)
+
+	w.OnChange = func(cfg *Config) { configs <- cfg }
+	w.OnError = func(err error) { errs <- err }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error)
+
+	go func() { done <- w.Start(ctx) }()
+
+	// Give the watcher time to start watching the directory
+	time.Sleep(100 * time.Millisecond)
+
+	assert.NoError(t, os.WriteFile(path, []byte("defaultContainers: [app, sidecar]\n"), 0o600))
+
+	select {
+	case cfg := <-configs:
+		assert.Equal(t, []string{"app", "sidecar"}, cfg.DefaultContainers)
+	case <-time.After(5 * time.Second):
+		t.Fatal("configuration was not reloaded")
+	}
+
+	assert.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(path), "other.yaml"), []byte("sinks: [Unknown]\n"), 0o600))
+
+	select {
+	case cfg := <-configs:
+		t.Fatalf("other file triggered the reload: %v", cfg)
+	case err := <-errs:
+		t.Fatalf("other file triggered the reload: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	assert.NoError(t, os.WriteFile(path, nil, 0o600))
+
+	select {
+	case cfg := <-configs:
+		t.Fatalf("empty configuration was applied: %v", cfg)
+	case err := <-errs:
+		assert.EqualError(t, err, "config file is empty")
+	case <-time.After(5 * time.Second):
+		t.Fatal("empty configuration was not reported")
+	}
+
+	assert.NoError(t, os.WriteFile(path, []byte("sinks: [Unknown]\n"), 0o600))
+
+	select {
+	case err := <-errs:
+		assert.ErrorContains(t, err, `unknown sink "Unknown"`)
+	case <-time.After(5 * time.Second):
+		t.Fatal("invalid configuration was not reported")
+	}
+
+	cancel()
+	assert.NoError(t, <-done)
+}
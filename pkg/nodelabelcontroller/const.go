@@ -16,7 +16,123 @@ limitations under the License.
 
 package nodelabelcontroller
 
+import "time"
+
+const (
+	// defaultAnnotationDomain is the domain of the exporter annotations if it is not configured
+	defaultAnnotationDomain = "node-labels-exporter.sinextra.dev"
+
+	defaultJSONEnv = "NODE_LABELS_JSON"
+
+	// requiredAllLabels is the required labels annotation value requiring all exported labels
+	requiredAllLabels = "*"
+)
+
+const (
+	// nodeCacheWaitTimeout is the time to wait for the informer to see the node not found in the cache
+	nodeCacheWaitTimeout = 2 * time.Second
+	// nodeCacheWaitInterval is the interval of the informer cache checks
+	nodeCacheWaitInterval = 100 * time.Millisecond
+	// nodeGetQPS is the rate of the direct node requests after the cache misses
+	nodeGetQPS = 5
+	// nodeGetBurst is the burst of the direct node requests after the cache misses
+	nodeGetBurst = 10
+)
+
 const (
-	annContainers = "node-labels-exporter.sinextra.dev/containers"
-	annKeyPrefix  = "injector.node-labels-exporter.sinextra.dev/"
+	// containerTypeContainers is the regular containers
+	containerTypeContainers = "containers"
+	// containerTypeInit is the init containers
+	containerTypeInit = "init"
+	// containerTypeSidecars is the native sidecars, the init containers with restartPolicy Always
+	containerTypeSidecars = "sidecars"
+	// containerTypeEphemeral is the ephemeral containers
+	containerTypeEphemeral = "ephemeral"
 )
+
+const (
+	// eventReasonLabelsApplied is the reason of the event on the pod with the node labels applied at bind time
+	eventReasonLabelsApplied = "NodeLabelsApplied"
+	// eventReasonLabelsMissing is the reason of the event on the pod bound to the node without the exported labels
+	eventReasonLabelsMissing = "NodeLabelsMissing"
+	// eventReasonPatchRetry is the reason of the event on the pod with the failed patch retried
+	eventReasonPatchRetry = "NodeLabelsPatchRetry"
+	// eventReasonPatchFailed is the reason of the event on the pod with the failed patch
+	eventReasonPatchFailed = "NodeLabelsPatchFailed"
+	// eventReasonBindingDenied is the reason of the event on the strict mode pod with the denied binding
+	eventReasonBindingDenied = "NodeLabelsBindingDenied"
+)
+
+// annotationNames is the set of annotation names of the exporter instance, they share the annotation domain
+type annotationNames struct {
+	containers string
+	// excludeContainers is a comma-separated list of container names or glob patterns excluded from the injection
+	excludeContainers string
+	// containerTypes is a comma-separated list of container types: containers, init, sidecars and ephemeral
+	containerTypes string
+	keyPrefix      string
+	// namespaceDefaults set to "false" opts the pod out of the namespace default annotations
+	namespaceDefaults string
+	// profile is a comma-separated list of named export profiles
+	profile string
+	// envPrefix is the prefix of all environment variable names of the pod
+	envPrefix string
+	// envConflictPolicy is the action for the environment variables already defined in the containers
+	envConflictPolicy string
+	// envPlacement is the position of the injected environment variables in the container
+	envPlacement string
+	// labelConflictPolicy is the action for the pod labels already set and not owned by the exporter
+	labelConflictPolicy string
+	// requiredLabels is a comma-separated list of node label keys the node must have, "*" means all exported labels,
+	// it puts the pod in the strict mode
+	requiredLabels string
+	// podLabelPrefix is the pod label key prefix replacing the node label key prefix
+	podLabelPrefix string
+
+	// jsonLabels is a comma-separated list of node label keys and prefixes exported as a single JSON document
+	jsonLabels string
+	// jsonEnv overrides the environment variable name of the JSON document
+	jsonEnv string
+	// nodeLabels is the pod annotation the JSON document is stored in at bind time
+	nodeLabels string
+	// ownedLabels is the pod annotation listing the pod labels written by the exporter instance
+	ownedLabels string
+	// injectedEnvs is the pod annotation listing the environment variables injected by the exporter instance,
+	// it marks the pod already handled on the webhook reinvocation
+	injectedEnvs string
+	// provenance is the pod annotation with the source node, the exporter version, the timestamp and the keys written
+	provenance string
+	// config is the structured JSON or YAML export configuration, it wins over the other annotations
+	config string
+}
+
+var defaultAnnotations = newAnnotationNames(defaultAnnotationDomain)
+
+// newAnnotationNames returns the annotation names of the domain, empty domain is the default one
+func newAnnotationNames(domain string) annotationNames {
+	if domain == "" {
+		domain = defaultAnnotationDomain
+	}
+
+	return annotationNames{
+		containers:          domain + "/containers",
+		excludeContainers:   domain + "/exclude-containers",
+		containerTypes:      domain + "/container-types",
+		keyPrefix:           "injector." + domain + "/",
+		namespaceDefaults:   domain + "/namespace-defaults",
+		profile:             domain + "/profile",
+		envPrefix:           domain + "/env-prefix",
+		envConflictPolicy:   domain + "/env-conflict-policy",
+		envPlacement:        domain + "/env-placement",
+		labelConflictPolicy: domain + "/label-conflict-policy",
+		requiredLabels:      domain + "/required-labels",
+		podLabelPrefix:      domain + "/pod-label-prefix",
+		jsonLabels:          domain + "/json-labels",
+		jsonEnv:             domain + "/json-env",
+		nodeLabels:          domain + "/node-labels",
+		ownedLabels:         domain + "/owned-labels",
+		injectedEnvs:        domain + "/injected-envs",
+		provenance:          domain + "/provenance",
+		config:              domain + "/config",
+	}
+}
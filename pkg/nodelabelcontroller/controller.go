@@ -20,19 +20,34 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-logr/logr"
 
+	"github.com/sergelogvinov/node-labels-exporter/pkg/config"
+
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/client-go/util/workqueue"
 
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
@@ -42,127 +57,813 @@ type NodeLabelsEnvInjector struct {
 	log     logr.Logger
 	decoder admission.Decoder
 
-	nodeLister corelisters.NodeLister
+	nodeLister      corelisters.NodeLister
+	namespaceLister corelisters.NamespaceLister
+	cfg             atomic.Pointer[config.Config]
+	recorder        record.EventRecorder
+	version         string
+	now             func() time.Time
+
+	// nodeCacheWait is the time to wait for the informer on the node cache miss
+	nodeCacheWait time.Duration
+	// nodeGetLimiter limits the direct node requests on the node cache misses
+	nodeGetLimiter flowcontrol.RateLimiter
+	// patches retries the failed binding patches after the admission response
+	patches *patchQueue
+
+	policies     client.Client
+	policyStatus *policyStatus
 }
 
-// NewNodeLabelsEnvInjector creates a new NodeLabelsEnvInjector
-func NewNodeLabelsEnvInjector(client kubernetes.Interface, scheme *runtime.Scheme, nodeLister corelisters.NodeLister, log logr.Logger) *NodeLabelsEnvInjector {
-	return &NodeLabelsEnvInjector{
-		client:     client,
-		log:        log,
-		decoder:    admission.NewDecoder(scheme),
-		nodeLister: nodeLister,
+// NewNodeLabelsEnvInjector creates a new NodeLabelsEnvInjector,
+// policies is the client to read the export policies, nil disables them,
+// recorder records the events on the pods, nil disables them,
+// version is the exporter version recorded in the provenance annotation
+func NewNodeLabelsEnvInjector(
+	clientset kubernetes.Interface,
+	scheme *runtime.Scheme,
+	nodeLister corelisters.NodeLister,
+	namespaceLister corelisters.NamespaceLister,
+	policies client.Client,
+	cfg *config.Config,
+	recorder record.EventRecorder,
+	version string,
+	log logr.Logger,
+) *NodeLabelsEnvInjector {
+	i := &NodeLabelsEnvInjector{
+		client:          clientset,
+		log:             log,
+		decoder:         admission.NewDecoder(scheme),
+		nodeLister:      nodeLister,
+		namespaceLister: namespaceLister,
+		recorder:        recorder,
+		version:         version,
+		now:             time.Now,
+		nodeCacheWait:   nodeCacheWaitTimeout,
+		nodeGetLimiter:  flowcontrol.NewTokenBucketRateLimiter(nodeGetQPS, nodeGetBurst),
+		patches: newPatchQueue(
+			workqueue.NewTypedItemExponentialFailureRateLimiter[types.NamespacedName](patchQueueBaseDelay, patchQueueMaxDelay),
+			patchQueueMaxRetries,
+		),
+		policies:     policies,
+		policyStatus: newPolicyStatus(),
 	}
+
+	i.SetConfig(cfg)
+
+	return i
+}
+
+// SetConfig replaces the configuration, it is safe to call while the requests are handled
+func (i *NodeLabelsEnvInjector) SetConfig(cfg *config.Config) {
+	if cfg == nil {
+		cfg = &config.Config{}
+	}
+
+	i.cfg.Store(cfg)
+}
+
+// config returns the current configuration
+func (i *NodeLabelsEnvInjector) config() *config.Config {
+	return i.cfg.Load()
 }
 
 // Handle handles the admission request
 func (i *NodeLabelsEnvInjector) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation == admissionv1.Update && req.RequestKind.Kind == "Pod" && req.SubResource == "ephemeralcontainers" {
+		i.log.V(1).Info("Handling request", "kind", req.RequestKind.Kind, "subresource", req.SubResource, "namespace", req.Namespace, "uid", req.UID)
+
+		return i.handleEphemeralContainers(ctx, req)
+	}
+
 	if req.Operation != admissionv1.Create {
 		return admission.Allowed("not a Create request")
 	}
 
 	i.log.V(1).Info("Handling request", "kind", req.RequestKind.Kind, "namespace", req.Namespace, "uid", req.UID)
 
-	if req.RequestKind.Kind == "Pod" {
-		pod := &corev1.Pod{}
-		if err := i.decoder.Decode(req, pod); err != nil { //nolint: noinlineerr
-			i.log.Error(err, "Failed to decode request object")
+	switch req.RequestKind.Kind {
+	case "Pod":
+		return i.handlePod(ctx, req)
+	case "Binding":
+		return i.handleBinding(ctx, req)
+	}
 
-			return admission.Errored(http.StatusBadRequest, err)
-		}
+	return admission.Allowed("done")
+}
 
-		name := pod.Name
-		if name == "" {
-			name = pod.GenerateName
-		}
+// handlePod injects the environment variables to the pod on creation
+func (i *NodeLabelsEnvInjector) handlePod(ctx context.Context, req admission.Request) admission.Response {
+	pod := &corev1.Pod{}
+	if err := i.decoder.Decode(req, pod); err != nil { //nolint: noinlineerr
+		i.log.Error(err, "Failed to decode request object")
 
-		i.log.V(1).Info("Handling request", "namespace", pod.Namespace, "name", name)
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	name := pod.Name
+	if name == "" {
+		name = pod.GenerateName
+	}
+
+	i.log.V(1).Info("Handling request", "namespace", pod.Namespace, "name", name)
+
+	// The webhook reinvocation gets the pod with the earlier changes, the injection is idempotent
+	ann := newAnnotationNames(i.config().AnnotationDomain)
+	_, reinvoked := pod.Annotations[ann.injectedEnvs]
+
+	spec := i.exportSpec(ctx, req.Namespace, pod, !reinvoked)
+	if spec.err != nil {
+		i.log.Info("Invalid export configuration", "namespace", pod.Namespace, "name", name, "error", spec.err.Error())
+
+		return admission.Denied(spec.err.Error()).WithWarnings(spec.warnings...)
+	}
+
+	var (
+		node          *corev1.Node
+		nodeLabelKeys []string
+	)
 
-		if !setEnvValueFromToPod(pod) {
-			return admission.Allowed("skipped")
+	// The pod created with the node name skips the binding, the node labels are exported at creation
+	if pod.Spec.NodeName != "" && !spec.isEmpty() {
+		n, err := i.getNode(ctx, pod.Spec.NodeName)
+		if err != nil {
+			i.log.Error(err, "Failed to get node", "node", pod.Spec.NodeName)
+		} else {
+			node = n
+			nodeLabelKeys = slices.Collect(maps.Keys(node.Labels))
 		}
+	}
 
-		podRaw, err := json.Marshal(pod)
+	if node == nil && spec.hasLabelPrefix() {
+		keys, err := i.nodeLabelKeys()
 		if err != nil {
-			i.log.Error(err, "Failed to encode pod object")
+			i.log.Error(err, "Failed to list nodes")
 
 			return admission.Errored(http.StatusInternalServerError, err)
 		}
 
-		i.log.Info("Injecting envFrom to pod", "namespace", pod.Namespace, "name", name)
+		nodeLabelKeys = keys
+	}
 
-		return admission.PatchResponseFromRaw(req.Object.Raw, podRaw)
+	warnings := slices.Clone(spec.warnings)
+	if len(warnings) > 0 {
+		i.log.Info("Invalid exports", "namespace", pod.Namespace, "name", name, "warnings", warnings)
 	}
 
-	if req.RequestKind.Kind == "Binding" {
-		binding := &corev1.Binding{}
-		if err := json.Unmarshal(req.Object.Raw, binding); err != nil { //nolint: noinlineerr
-			i.log.Error(err, "Failed to decode request object")
+	if denied := spec.denied(nodeLabelKeys); len(denied) > 0 {
+		i.log.Info("Node labels are not allowed to be exported", "namespace", pod.Namespace, "name", name, "labels", denied)
+
+		warnings = append(warnings, deniedWarnings(denied)...)
+	}
 
-			return admission.Errored(http.StatusBadRequest, fmt.Errorf("json unmarshal Binding with error: %v", err))
+	if unknown := unknownContainers(pod, parseLabelKeys(pod.Annotations[ann.containers])); len(unknown) > 0 {
+		for _, pattern := range unknown {
+			warnings = append(warnings, fmt.Sprintf("annotation %q: container %q is not found in the pod", ann.containers, pattern))
 		}
+	}
 
-		if binding.Target.Kind != "Node" || binding.Target.Name == "" {
-			i.log.Info("Pod binding target is not Node or target name empty", "binding", binding)
+	if spec.labelConflict == config.LabelConflictFail {
+		if conflicts := podLabelConflicts(pod, spec, nodeLabelKeys); len(conflicts) > 0 {
+			messages := conflictMessages(conflicts)
 
-			return admission.Allowed("skipped")
+			i.log.Info("Pod labels are already set", "namespace", pod.Namespace, "name", name, "conflicts", messages)
+
+			return admission.Denied(strings.Join(messages, ", ")).WithWarnings(warnings...)
 		}
+	}
 
-		i.log.V(1).Info("Handling request", "node", binding.Target.Name, "namespace", binding.Namespace, "name", binding.Name)
+	var (
+		updated   bool
+		conflicts []envConflict
+		source    *provenance
+	)
 
-		pod, err := i.client.CoreV1().Pods(binding.Namespace).Get(ctx, binding.Name, metav1.GetOptions{})
+	if node != nil {
+		labels, labelConflicts := setLabelsToPod(node, pod, spec)
+		if len(labelConflicts) > 0 {
+			messages := conflictMessages(labelConflicts)
+
+			i.log.Info("Pod labels are already set", "namespace", pod.Namespace, "name", name, "policy", spec.labelConflict, "conflicts", messages)
+
+			warnings = append(warnings, messages...)
+		}
+
+		doc, err := setLabelsJSONToPod(node, pod, spec)
 		if err != nil {
-			i.log.Error(err, "Failed to get pod", "namespace", binding.Namespace, "name", binding.Name)
+			i.log.Error(err, "Failed to encode node labels", "node", node.Name)
 
-			return admission.Errored(http.StatusInternalServerError, fmt.Errorf("failed to get pod %s/%s: %v", binding.Namespace, binding.Name, err))
+			return admission.Errored(http.StatusInternalServerError, err)
 		}
 
-		node, err := i.nodeLister.Get(binding.Target.Name)
+		envs, err := getEnvsFromNode(node, spec)
 		if err != nil {
-			i.log.Error(err, "Failed to get node", "node", binding.Target.Name)
+			i.log.Error(err, "Failed to encode node labels", "node", node.Name)
 
-			return admission.Errored(http.StatusInternalServerError, fmt.Errorf("failed to get node %s: %v", binding.Target.Name, err))
+			return admission.Errored(http.StatusInternalServerError, err)
 		}
 
-		updated := pod.DeepCopy()
+		updated, conflicts = setEnvsToPod(pod, spec, envs)
+		updated = updated || len(labels) > 0 || doc != ""
+
+		if updated {
+			var annotations []string
+			if doc != "" {
+				annotations = append(annotations, spec.json.annotation)
+			}
 
-		labels := setLabelsToPod(node, updated)
-		if len(labels) == 0 {
-			return admission.Allowed("skipped")
+			p := newProvenance(node.Name, i.version, i.now(), labels, annotations...)
+			source = &p
 		}
 
-		i.log.Info("Injecting node labels to pod", "namespace", binding.Namespace, "name", binding.Name, "labels", labels)
+		if missing := spec.missing(node); len(missing) > 0 {
+			i.log.Info("Node labels are not set", "namespace", pod.Namespace, "name", name, "node", node.Name, "labels", missing)
 
-		updatedBytes, err := json.Marshal(updated)
-		if err != nil {
-			i.log.Error(err, "Failed to encode new pod object")
+			warnings = append(warnings, missingWarnings(node.Name, missing)...)
+		}
 
-			return admission.Errored(http.StatusInternalServerError, err)
+		i.log.V(1).Info("Exporting node labels at creation", "namespace", pod.Namespace, "name", name, "node", node.Name, "labels", labels)
+	} else {
+		updated, conflicts = setEnvValueFromToPod(pod, spec, nodeLabelKeys)
+	}
+
+	if !updated {
+		return admission.Allowed("skipped").WithWarnings(warnings...)
+	}
+
+	if len(conflicts) > 0 {
+		messages := conflictMessages(conflicts)
+
+		i.log.Info("Environment variables are already defined", "namespace", pod.Namespace, "name", name, "policy", spec.envConflict, "conflicts", messages)
+
+		if spec.envConflict == config.EnvConflictFail {
+			return admission.Denied(strings.Join(messages, ", ")).WithWarnings(warnings...)
 		}
 
-		podBytes, err := json.Marshal(pod)
-		if err != nil {
-			i.log.Error(err, "Failed to encode old pod object")
+		warnings = append(warnings, messages...)
+	}
+
+	audit := map[string]string{auditVersion: i.version}
+
+	if source != nil {
+		if err := setProvenanceToPod(pod, ann.provenance, *source); err != nil { //nolint: noinlineerr
+			i.log.Error(err, "Failed to encode provenance")
 
 			return admission.Errored(http.StatusInternalServerError, err)
 		}
 
-		patchBytes, err := strategicpatch.CreateTwoWayMergePatch(podBytes, updatedBytes, &corev1.Pod{})
+		audit = source.auditAnnotations()
+	}
+
+	if envs := pod.Annotations[ann.injectedEnvs]; envs != "" {
+		audit[auditEnvs] = envs
+	}
+
+	podRaw, err := json.Marshal(pod)
+	if err != nil {
+		i.log.Error(err, "Failed to encode pod object")
+
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	i.log.Info("Injecting envFrom to pod", "namespace", pod.Namespace, "name", name, "reinvoked", reinvoked)
+
+	resp := admission.PatchResponseFromRaw(req.Object.Raw, podRaw).WithWarnings(warnings...)
+	resp.AuditAnnotations = audit
+
+	return resp
+}
+
+// handleEphemeralContainers injects the environment variables to the ephemeral containers added by kubectl debug,
+// the existing containers can not be changed by the subresource update
+func (i *NodeLabelsEnvInjector) handleEphemeralContainers(ctx context.Context, req admission.Request) admission.Response {
+	pod := &corev1.Pod{}
+	if err := i.decoder.Decode(req, pod); err != nil { //nolint: noinlineerr
+		i.log.Error(err, "Failed to decode request object")
+
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	oldPod := &corev1.Pod{}
+	if err := i.decoder.DecodeRaw(req.OldObject, oldPod); err != nil { //nolint: noinlineerr
+		i.log.Error(err, "Failed to decode old request object")
+
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	spec := i.exportSpec(ctx, req.Namespace, pod, false)
+	if spec.err != nil {
+		i.log.Info("Invalid export configuration", "namespace", pod.Namespace, "name", pod.Name, "error", spec.err.Error())
+
+		return admission.Allowed("skipped")
+	}
+
+	if len(spec.containerTypes) > 0 && !slices.Contains(spec.containerTypes, containerTypeEphemeral) {
+		return admission.Allowed("skipped")
+	}
+
+	// Only the added ephemeral containers are selected, the subresource update keeps the pod metadata
+	spec.containerTypes = []string{containerTypeEphemeral}
+	spec.injectedEnvs = ""
+
+	spec.excludeContainers = slices.Clone(spec.excludeContainers)
+	for _, c := range oldPod.Spec.EphemeralContainers {
+		spec.excludeContainers = append(spec.excludeContainers, c.Name)
+	}
+
+	var nodeLabelKeys []string
+
+	if spec.hasLabelPrefix() {
+		keys, err := i.nodeLabelKeys()
 		if err != nil {
-			i.log.Error(err, "Failed to create patch")
+			i.log.Error(err, "Failed to list nodes")
 
 			return admission.Errored(http.StatusInternalServerError, err)
 		}
 
-		_, err = i.client.CoreV1().Pods(binding.Namespace).Patch(ctx, binding.Name, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
-		if err != nil {
-			i.log.Error(err, "Failed to patch pod", "namespace", binding.Namespace, "name", binding.Name)
+		nodeLabelKeys = keys
+	}
 
-			return admission.Errored(http.StatusInternalServerError, err)
+	warnings := []string{}
+
+	updated, conflicts := setEnvValueFromToPod(pod, spec, nodeLabelKeys)
+	if !updated {
+		return admission.Allowed("skipped")
+	}
+
+	if len(conflicts) > 0 {
+		messages := conflictMessages(conflicts)
+
+		i.log.Info("Environment variables are already defined", "namespace", pod.Namespace, "name", pod.Name, "policy", spec.envConflict, "conflicts", messages)
+
+		if spec.envConflict == config.EnvConflictFail {
+			return admission.Denied(strings.Join(messages, ", "))
 		}
 
-		return admission.Allowed("patched")
+		warnings = append(warnings, messages...)
 	}
 
-	return admission.Allowed("done")
+	podRaw, err := json.Marshal(pod)
+	if err != nil {
+		i.log.Error(err, "Failed to encode pod object")
+
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	i.log.Info("Injecting envFrom to ephemeral containers", "namespace", pod.Namespace, "name", pod.Name)
+
+	return admission.PatchResponseFromRaw(req.Object.Raw, podRaw).WithWarnings(warnings...)
+}
+
+// handleBinding copies the node labels to the pod when it is bound to the node
+func (i *NodeLabelsEnvInjector) handleBinding(ctx context.Context, req admission.Request) admission.Response {
+	binding := &corev1.Binding{}
+	if err := json.Unmarshal(req.Object.Raw, binding); err != nil { //nolint: noinlineerr
+		i.log.Error(err, "Failed to decode request object")
+
+		return admission.Errored(http.StatusBadRequest, fmt.Errorf("json unmarshal Binding with error: %v", err))
+	}
+
+	if binding.Target.Kind != "Node" || binding.Target.Name == "" {
+		i.log.Info("Pod binding target is not Node or target name empty", "binding", binding)
+
+		return admission.Allowed("skipped")
+	}
+
+	i.log.V(1).Info("Handling request", "node", binding.Target.Name, "namespace", binding.Namespace, "name", binding.Name)
+
+	pod, err := i.client.CoreV1().Pods(binding.Namespace).Get(ctx, binding.Name, metav1.GetOptions{})
+	if err != nil {
+		i.log.Error(err, "Failed to get pod", "namespace", binding.Namespace, "name", binding.Name)
+
+		// The pod annotations are unknown, the namespace annotations and policies decide the strict mode.
+		// The scheduler sets the pod UID to the binding.
+		ref := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: binding.Name, Namespace: binding.Namespace, UID: binding.UID}}
+
+		return i.bindingFailed(i.exportSpec(ctx, binding.Namespace, ref, false), ref, binding.Target.Name,
+			fmt.Errorf("failed to get pod %s/%s: %v", binding.Namespace, binding.Name, err))
+	}
+
+	ann := newAnnotationNames(i.config().AnnotationDomain)
+	updated := pod.DeepCopy()
+	spec := i.exportSpec(ctx, pod.Namespace, pod, false)
+	if spec.err != nil {
+		i.log.Info("Invalid export configuration", "namespace", binding.Namespace, "name", binding.Name, "error", spec.err.Error())
+	}
+
+	// The node lookup may wait for the informer and the rate-limited API request, the pods exporting nothing skip it
+	if spec.isEmpty() && !spec.strict() {
+		return admission.Allowed("skipped").WithWarnings(spec.warnings...)
+	}
+
+	node, err := i.getNode(ctx, binding.Target.Name)
+	if err != nil {
+		i.log.Error(err, "Failed to get node", "node", binding.Target.Name)
+
+		return i.bindingFailed(spec, pod, binding.Target.Name, fmt.Errorf("failed to get node %s: %v", binding.Target.Name, err))
+	}
+
+	warnings := slices.Clone(spec.warnings)
+
+	if denied := spec.denied(slices.Collect(maps.Keys(node.Labels))); len(denied) > 0 {
+		i.log.Info("Node labels are not allowed to be exported", "namespace", binding.Namespace, "name", binding.Name, "labels", denied)
+
+		warnings = append(warnings, deniedWarnings(denied)...)
+	}
+
+	if missing := spec.missing(node); len(missing) > 0 {
+		i.log.Info("Node labels are not set", "namespace", binding.Namespace, "name", binding.Name, "node", node.Name, "labels", missing)
+
+		warnings = append(warnings, missingWarnings(node.Name, missing)...)
+		i.event(pod, corev1.EventTypeWarning, eventReasonLabelsMissing, "Node %s has no labels: %s", node.Name, strings.Join(missing, ", "))
+	}
+
+	exported, conflicts := setLabelsToPod(node, updated, spec)
+	if len(conflicts) > 0 {
+		messages := conflictMessages(conflicts)
+
+		i.log.Info("Pod labels are already set", "namespace", binding.Namespace, "name", binding.Name, "policy", spec.labelConflict, "conflicts", messages)
+
+		warnings = append(warnings, messages...)
+	}
+
+	doc, err := setLabelsJSONToPod(node, updated, spec)
+	if err != nil {
+		i.log.Error(err, "Failed to encode node labels", "node", binding.Target.Name)
+
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	// The required labels are checked on the updated pod, the denied and the kept pod labels are not exported
+	if missing := spec.missingRequired(node, updated); spec.strict() && len(missing) > 0 {
+		msg := fmt.Sprintf("node %q has no required labels: %s", node.Name, strings.Join(missing, ", "))
+
+		i.log.Info("Binding is denied", "namespace", binding.Namespace, "name", binding.Name, "node", node.Name, "reason", msg)
+		i.event(pod, corev1.EventTypeWarning, eventReasonBindingDenied, "Binding to node %s is denied: %s", node.Name, msg)
+
+		return admission.Denied(msg).WithWarnings(warnings...)
+	}
+
+	if len(exported) == 0 && doc == "" {
+		return admission.Allowed("skipped").WithWarnings(warnings...)
+	}
+
+	i.log.Info("Injecting node labels to pod", "namespace", binding.Namespace, "name", binding.Name, "labels", exported, "json", doc)
+
+	var annotations []string
+	if doc != "" {
+		annotations = append(annotations, spec.json.annotation)
+	}
+
+	source := newProvenance(node.Name, i.version, i.now(), exported, annotations...)
+	if err := setProvenanceToPod(updated, ann.provenance, source); err != nil { //nolint: noinlineerr
+		i.log.Error(err, "Failed to encode provenance")
+
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	updatedBytes, err := json.Marshal(updated)
+	if err != nil {
+		i.log.Error(err, "Failed to encode new pod object")
+
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	podBytes, err := json.Marshal(pod)
+	if err != nil {
+		i.log.Error(err, "Failed to encode old pod object")
+
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	patchBytes, err := strategicpatch.CreateTwoWayMergePatch(podBytes, updatedBytes, &corev1.Pod{})
+	if err != nil {
+		i.log.Error(err, "Failed to create patch")
+
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	if err = i.patchPod(ctx, pod, patchBytes); err != nil { //nolint: noinlineerr
+		if spec.strict() {
+			msg := fmt.Sprintf("failed to apply node %q labels to the pod: %v", node.Name, err)
+
+			i.log.Error(err, "Binding is denied, failed to patch pod", "namespace", binding.Namespace, "name", binding.Name, "node", node.Name)
+			i.event(pod, corev1.EventTypeWarning, eventReasonBindingDenied, "Binding to node %s is denied: %s", node.Name, msg)
+
+			return admission.Denied(msg).WithWarnings(warnings...)
+		}
+
+		if isRetriable(err) {
+			i.log.Info("Failed to patch pod, queued for retry", "namespace", binding.Namespace, "name", binding.Name, "error", err.Error())
+			i.patches.add(&podPatch{pod: pod, node: node.Name, patch: patchBytes})
+
+			resp := admission.Allowed("queued").WithWarnings(append(warnings, fmt.Sprintf("pod patch failed, it is queued for retry: %v", err))...)
+			resp.AuditAnnotations = source.auditAnnotations()
+
+			return resp
+		}
+
+		i.log.Error(err, "Failed to patch pod", "namespace", binding.Namespace, "name", binding.Name)
+		i.event(pod, corev1.EventTypeWarning, eventReasonPatchFailed, "Failed to apply node %s labels: %v", node.Name, err)
+
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	if len(exported) > 0 {
+		msg := fmt.Sprintf("Applied node %s labels: %s", node.Name, strings.Join(slices.Sorted(maps.Keys(exported)), ", "))
+		if envs := pod.Annotations[ann.injectedEnvs]; envs != "" {
+			msg += ", environment variables: " + envs
+		}
+
+		i.event(pod, corev1.EventTypeNormal, eventReasonLabelsApplied, "%s", msg)
+	}
+
+	if doc != "" {
+		i.event(pod, corev1.EventTypeNormal, eventReasonLabelsApplied, "Applied node %s labels as JSON document", node.Name)
+	}
+
+	resp := admission.Allowed("patched").WithWarnings(warnings...)
+	resp.AuditAnnotations = source.auditAnnotations()
+
+	return resp
+}
+
+// getNamespace returns the namespace from the informer cache, the namespace missing in the cache
+// is requested directly, e.g. the pod is created right after its namespace
+func (i *NodeLabelsEnvInjector) getNamespace(ctx context.Context, name string) (*corev1.Namespace, error) {
+	ns, err := i.namespaceLister.Get(name)
+	if err == nil || !apierrors.IsNotFound(err) {
+		return ns, err
+	}
+
+	return i.client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+}
+
+// bindingFailed returns the error response of the binding, the binding of the strict mode pod is denied
+func (i *NodeLabelsEnvInjector) bindingFailed(spec exportSpec, pod *corev1.Pod, node string, err error) admission.Response {
+	if !spec.strict() {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	i.log.Info("Binding is denied", "namespace", pod.Namespace, "name", pod.Name, "node", node, "reason", err.Error())
+	i.event(pod, corev1.EventTypeWarning, eventReasonBindingDenied, "Binding to node %s is denied: %v", node, err)
+
+	return admission.Denied(err.Error()).WithWarnings(spec.warnings...)
+}
+
+// getNode returns the node from the informer cache. The new node can be missing in the cache,
+// so it waits for the informer and then requests the node directly with the rate limit
+func (i *NodeLabelsEnvInjector) getNode(ctx context.Context, name string) (*corev1.Node, error) {
+	node, err := i.nodeLister.Get(name)
+	if err == nil || !apierrors.IsNotFound(err) {
+		return node, err
+	}
+
+	i.log.V(1).Info("Node is not found in the cache, waiting for the informer", "node", name)
+
+	err = wait.PollUntilContextTimeout(ctx, nodeCacheWaitInterval, i.nodeCacheWait, false, func(context.Context) (bool, error) {
+		node, err = i.nodeLister.Get(name)
+
+		return err == nil, nil
+	})
+	if err == nil {
+		nodeCacheMisses.WithLabelValues(nodeCacheMissInformer).Inc()
+
+		return node, nil
+	}
+
+	if err = i.nodeGetLimiter.Wait(ctx); err != nil { //nolint: noinlineerr
+		nodeCacheMisses.WithLabelValues(nodeCacheMissFailed).Inc()
+
+		return nil, fmt.Errorf("node request is rate limited: %v", err)
+	}
+
+	node, err = i.client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		nodeCacheMisses.WithLabelValues(nodeCacheMissFailed).Inc()
+
+		return nil, err
+	}
+
+	i.log.Info("Node is not found in the cache, got it from the API server", "node", name)
+	nodeCacheMisses.WithLabelValues(nodeCacheMissAPI).Inc()
+
+	return node, nil
+}
+
+// patchPod applies the strategic merge patch to the pod, the transient errors are retried by the patch queue
+func (i *NodeLabelsEnvInjector) patchPod(ctx context.Context, pod *corev1.Pod, patch []byte) error {
+	_, err := i.client.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+
+	return err
+}
+
+// isRetriable reports whether the API error is transient
+func isRetriable(err error) bool {
+	return apierrors.IsConflict(err) ||
+		apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsInternalError(err)
+}
+
+// event records the event on the stored pod, the pod at creation has no UID
+// and its events are not shown by kubectl describe, so they are not recorded
+func (i *NodeLabelsEnvInjector) event(pod *corev1.Pod, eventType, reason, messageFmt string, args ...any) {
+	if i.recorder == nil {
+		return
+	}
+
+	ref := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      pod.Name,
+		Namespace: pod.Namespace,
+		UID:       pod.UID,
+	}}
+
+	i.recorder.Eventf(ref, eventType, reason, messageFmt, args...)
+}
+
+// exportSpec returns the pod export spec, the pod annotations win over the namespace annotations
+// and the namespace annotations win over the export policies. The configuration defaults, sinks
+// and node label filter are applied to the result.
+func (i *NodeLabelsEnvInjector) exportSpec(ctx context.Context, namespace string, pod *corev1.Pod, created bool) exportSpec {
+	cfg := i.config()
+	ann := newAnnotationNames(cfg.AnnotationDomain)
+
+	ns, err := i.getNamespace(ctx, namespace)
+	if err != nil {
+		i.log.Error(err, "Failed to get namespace", "namespace", namespace)
+	}
+
+	podAnnotations, defaults, warnings := i.podAnnotations(cfg, ann, ns, pod)
+
+	// The namespace options are overridden by the pod ones, the namespace exports are the defaults of the pod exports
+	annotations := mergeAnnotations(defaults, podAnnotations)
+
+	envPrefix := cfg.EnvPrefix
+	if prefix, ok := annotations[ann.envPrefix]; ok {
+		envPrefix = strings.TrimSpace(prefix)
+	}
+
+	spec := ann.exportSpec(podAnnotations).withDefaults(ann.exportSpec(defaults)).withDefaults(i.policySpec(ctx, namespace, ns, pod, created))
+	own := ann.exportSpec(pod.Annotations).withSinks(cfg.SinkEnabled).withEnvPrefix(envPrefix).envNames()
+	spec = spec.withSinks(cfg.SinkEnabled).withEnvPrefix(envPrefix).withValidEnvNames(own)
+	spec.warnings = slices.Concat(warnings, spec.warnings)
+
+	podLabelPrefix := cfg.PodLabelPrefix
+	if prefix, ok := annotations[ann.podLabelPrefix]; ok {
+		if err := config.ValidatePodLabelPrefix(strings.TrimSpace(prefix)); err != nil { //nolint: noinlineerr
+			spec.warnings = append(spec.warnings, fmt.Sprintf("annotation %q has invalid pod label prefix: %v", ann.podLabelPrefix, err))
+		} else {
+			podLabelPrefix = strings.TrimSpace(prefix)
+		}
+	}
+
+	if podLabelPrefix != "" || len(cfg.PodLabels) > 0 {
+		spec.podLabel = func(key string) string { return config.PodLabelKey(key, cfg.PodLabels, podLabelPrefix) }
+	}
+
+	var warning string
+
+	if spec.envConflict, warning = annotationPolicy(annotations, ann.envConflictPolicy, cfg.EnvConflictPolicy); warning != "" {
+		spec.warnings = append(spec.warnings, warning)
+	}
+
+	if spec.envPlacement, warning = annotationPolicy(annotations, ann.envPlacement, cfg.EnvPlacement); warning != "" {
+		spec.warnings = append(spec.warnings, warning)
+	}
+
+	if spec.labelConflict, warning = annotationPolicy(annotations, ann.labelConflictPolicy, cfg.LabelConflictPolicy); warning != "" {
+		spec.warnings = append(spec.warnings, warning)
+	}
+
+	spec.required = parseLabelKeys(annotations[ann.requiredLabels])
+
+	if len(spec.containers) == 0 {
+		spec.containers = cfg.DefaultContainers
+	}
+
+	if spec.json != nil {
+		e := *spec.json
+		e.annotation = ann.nodeLabels
+		spec.json = &e
+	}
+
+	if filter := cfg.Labels; !filter.IsEmpty() {
+		spec.allowed = func(key string) bool { return filter.Allowed(namespace, key) }
+	}
+
+	return spec
+}
+
+// conflictMessages returns the messages of the conflicts
+func conflictMessages[T fmt.Stringer](conflicts []T) []string {
+	messages := make([]string, 0, len(conflicts))
+	for _, c := range conflicts {
+		messages = append(messages, c.String())
+	}
+
+	return messages
+}
+
+// deniedWarnings returns the warnings of the node label keys which may not be exported
+func deniedWarnings(denied []string) []string {
+	warnings := make([]string, 0, len(denied))
+	for _, key := range denied {
+		warnings = append(warnings, fmt.Sprintf("node label %q is not allowed to be exported", key))
+	}
+
+	return warnings
+}
+
+// missingWarnings returns the warnings of the node label keys not set on the node
+func missingWarnings(node string, missing []string) []string {
+	warnings := make([]string, 0, len(missing))
+	for _, key := range missing {
+		warnings = append(warnings, fmt.Sprintf("node %q has no label %q", node, key))
+	}
+
+	return warnings
+}
+
+// annotationPolicy returns the policy set by the annotation or the default one, it also returns a warning for the unknown policy
+func annotationPolicy[T interface {
+	~string
+	IsValid() bool
+}](annotations map[string]string, key string, defaultPolicy T) (T, string) {
+	value, ok := annotations[key]
+	if !ok {
+		return defaultPolicy, ""
+	}
+
+	if policy := T(strings.TrimSpace(value)); policy.IsValid() {
+		return policy, ""
+	}
+
+	return defaultPolicy, fmt.Sprintf("annotation %q has unknown policy %q", key, value)
+}
+
+// podAnnotations returns the pod annotations and the namespace defaults with the export profiles expanded,
+// the invalid structured configuration of the namespace is ignored with a warning, so it does not block all pods of the namespace.
+// The unknown export profiles are reported in the warnings.
+func (i *NodeLabelsEnvInjector) podAnnotations(
+	cfg *config.Config,
+	ann annotationNames,
+	ns *corev1.Namespace,
+	pod *corev1.Pod,
+) (map[string]string, map[string]string, []string) {
+	var warnings []string
+
+	annotations, unknown := ann.expandProfiles(pod.Annotations, cfg.Profiles)
+	if len(unknown) > 0 {
+		i.log.Info("Unknown export profiles", "namespace", pod.Namespace, "name", pod.Name, "profiles", unknown)
+
+		for _, name := range unknown {
+			warnings = append(warnings, fmt.Sprintf("annotation %q has unknown profile %q", ann.profile, name))
+		}
+	}
+
+	if inherit, err := strconv.ParseBool(pod.Annotations[ann.namespaceDefaults]); ns == nil || (err == nil && !inherit) {
+		return annotations, nil, warnings
+	}
+
+	defaults, unknown := ann.expandProfiles(ann.inherited(ns.Annotations), cfg.Profiles)
+	if len(unknown) > 0 {
+		i.log.Info("Unknown export profiles", "namespace", ns.Name, "profiles", unknown)
+
+		for _, name := range unknown {
+			warnings = append(warnings, fmt.Sprintf("namespace %q annotation %q has unknown profile %q", ns.Name, ann.profile, name))
+		}
+	}
+
+	if value, ok := defaults[ann.config]; ok {
+		if _, err := podConfigExportSpec(value); err != nil { //nolint: noinlineerr
+			i.log.Info("Invalid namespace export configuration", "namespace", ns.Name, "error", err.Error())
+
+			warnings = append(warnings, fmt.Sprintf("namespace %q annotation %q is invalid and ignored: %v", ns.Name, ann.config, err))
+
+			delete(defaults, ann.config)
+		}
+	}
+
+	return annotations, defaults, warnings
+}
+
+// nodeLabelKeys returns the label keys of all known nodes
+func (i *NodeLabelsEnvInjector) nodeLabelKeys() ([]string, error) {
+	nodes, err := i.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+
+	keys := make(map[string]struct{})
+
+	for _, node := range nodes {
+		for k := range node.Labels {
+			keys[k] = struct{}{}
+		}
+	}
+
+	return slices.Sorted(maps.Keys(keys)), nil
 }
@@ -0,0 +1,1110 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelabelcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sergelogvinov/node-labels-exporter/pkg/config"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// newTestInjector returns the injector with the nodes and namespaces in the listers and the pods in the clientset,
+// the events are recorded by the fake recorder
+func newTestInjector(t *testing.T, nodes []*corev1.Node, namespaces []*corev1.Namespace, pods ...runtime.Object) *NodeLabelsEnvInjector {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+	assert.NoError(t, corev1.AddToScheme(scheme))
+
+	nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range nodes {
+		assert.NoError(t, nodeIndexer.Add(node))
+	}
+
+	namespaceIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, ns := range namespaces {
+		assert.NoError(t, namespaceIndexer.Add(ns))
+	}
+
+	return NewNodeLabelsEnvInjector(
+		fake.NewClientset(pods...),
+		scheme,
+		corelisters.NewNodeLister(nodeIndexer),
+		corelisters.NewNamespaceLister(namespaceIndexer),
+		nil,
+		nil,
+		record.NewFakeRecorder(16),
+		"v0.0.0-test",
+		logr.Discard(),
+	)
+}
+
+func rawObject(t *testing.T, obj any) runtime.RawExtension {
+	t.Helper()
+
+	raw, err := json.Marshal(obj)
+	assert.NoError(t, err)
+
+	return runtime.RawExtension{Raw: raw}
+}
+
+// patchedPod returns the pod with the admission response patches applied
+func patchedPod(t *testing.T, raw runtime.RawExtension, resp admission.Response) *corev1.Pod {
+	t.Helper()
+
+	patch, err := json.Marshal(resp.Patches)
+	assert.NoError(t, err)
+
+	decoded, err := jsonpatch.DecodePatch(patch)
+	assert.NoError(t, err)
+
+	patched, err := decoded.Apply(raw.Raw)
+	assert.NoError(t, err)
+
+	pod := &corev1.Pod{}
+	assert.NoError(t, json.Unmarshal(patched, pod))
+
+	return pod
+}
+
+func Test_handlePodWithNodeName(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node0",
+			Labels: map[string]string{
+				"topology.kubernetes.io/zone":   "zone-1",
+				"topology.kubernetes.io/region": "region-1",
+			},
+		},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod0",
+			Namespace: "default",
+			Annotations: map[string]string{
+				defaultAnnotations.keyPrefix + "zone": "topology.kubernetes.io/zone",
+				defaultAnnotations.keyPrefix + "rack": "topology.kubernetes.io/rack",
+				defaultAnnotations.jsonLabels:         "topology.kubernetes.io/region",
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app"}},
+		},
+	}
+
+	for _, tt := range []struct {
+		name     string
+		nodeName string
+		labels   map[string]string
+		envs     []corev1.EnvVar
+	}{
+		{
+			name:     "node name is set",
+			nodeName: "node0",
+			labels:   map[string]string{"topology.kubernetes.io/zone": "zone-1"},
+			envs: []corev1.EnvVar{
+				{Name: defaultJSONEnv, Value: `{"topology.kubernetes.io/region":"region-1"}`},
+				{Name: "ZONE", Value: "zone-1"},
+			},
+		},
+		{
+			name: "node name is not set",
+			envs: []corev1.EnvVar{
+				envVarFromField(defaultJSONEnv, "metadata.annotations['"+defaultAnnotations.nodeLabels+"']"),
+				envVarFromField("RACK", "metadata.labels['topology.kubernetes.io/rack']"),
+				envVarFromField("ZONE", "metadata.labels['topology.kubernetes.io/zone']"),
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := pod.DeepCopy()
+			p.Spec.NodeName = tt.nodeName
+
+			i := newTestInjector(t, []*corev1.Node{node}, []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "default"}}})
+
+			raw := rawObject(t, p)
+			resp := i.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation:   admissionv1.Create,
+				RequestKind: &metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Namespace:   "default",
+				Object:      raw,
+			}})
+
+			assert.True(t, resp.Allowed)
+
+			patched := patchedPod(t, raw, resp)
+			assert.Equal(t, tt.labels, patched.Labels)
+			assert.Equal(t, tt.envs, patched.Spec.Containers[0].Env)
+		})
+	}
+}
+
+func Test_handleEphemeralContainers(t *testing.T) {
+	oldPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod0",
+			Namespace: "default",
+			Annotations: map[string]string{
+				defaultAnnotations.keyPrefix + "zone": "topology.kubernetes.io/zone",
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:   "node0",
+			Containers: []corev1.Container{{Name: "app"}},
+			EphemeralContainers: []corev1.EphemeralContainer{
+				{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger-old"}},
+			},
+		},
+	}
+
+	for _, tt := range []struct {
+		name        string
+		annotations map[string]string
+		patched     bool
+	}{
+		{
+			name:    "added ephemeral container",
+			patched: true,
+		},
+		{
+			name:        "ephemeral containers are not selected by type",
+			annotations: map[string]string{defaultAnnotations.containerTypes: "containers"},
+		},
+		{
+			name:        "ephemeral container is not selected by name",
+			annotations: map[string]string{defaultAnnotations.containers: "app"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			old := oldPod.DeepCopy()
+			for k, v := range tt.annotations {
+				old.Annotations[k] = v
+			}
+
+			pod := old.DeepCopy()
+			pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+				EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger-new"},
+			})
+
+			i := newTestInjector(t, nil, []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "default"}}})
+
+			resp := i.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation:   admissionv1.Update,
+				RequestKind: &metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				SubResource: "ephemeralcontainers",
+				Namespace:   "default",
+				Object:      rawObject(t, pod),
+				OldObject:   rawObject(t, old),
+			}})
+
+			assert.True(t, resp.Allowed)
+
+			if !tt.patched {
+				assert.Empty(t, resp.Patches)
+
+				return
+			}
+
+			assert.NotEmpty(t, resp.Patches)
+
+			for _, p := range resp.Patches {
+				assert.True(t, strings.HasPrefix(p.Path, "/spec/ephemeralContainers/1"), "unexpected patch path %s", p.Path)
+			}
+		})
+	}
+}
+
+func Test_handlePodWarnings(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod0",
+			Namespace: "default",
+			Annotations: map[string]string{
+				defaultAnnotations.containers:          "app,sidecar",
+				defaultAnnotations.profile:             "topolgy",
+				defaultAnnotations.keyPrefix + "zone":  "topology.kubernetes.io/zone",
+				defaultAnnotations.keyPrefix + "asset": "sinextra.dev/asset-tag",
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app"}},
+		},
+	}
+
+	i := newTestInjector(t, nil, []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "default"}}})
+	i.SetConfig(&config.Config{Labels: config.LabelPolicy{LabelFilter: config.LabelFilter{Deny: []string{"sinextra.dev/*"}}}})
+
+	resp := i.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation:   admissionv1.Create,
+		RequestKind: &metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Namespace:   "default",
+		Object:      rawObject(t, pod),
+	}})
+
+	assert.True(t, resp.Allowed)
+	assert.Equal(t, []string{
+		`annotation "node-labels-exporter.sinextra.dev/profile" has unknown profile "topolgy"`,
+		`node label "sinextra.dev/asset-tag" is not allowed to be exported`,
+		`annotation "node-labels-exporter.sinextra.dev/containers": container "sidecar" is not found in the pod`,
+	}, resp.Warnings)
+}
+
+func Test_handlePodInvalidEnvNames(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		namespace   map[string]string
+		annotations map[string]string
+		allowed     bool
+		warnings    []string
+		message     string
+	}{
+		{
+			name: "pod annotation",
+			annotations: map[string]string{
+				defaultAnnotations.keyPrefix + "zone": "topology.kubernetes.io/zone",
+				defaultAnnotations.keyPrefix + "rack": "1RACK=topology.kubernetes.io/rack",
+			},
+			message: `environment variable names "1RACK" are invalid, they must consist of letters, digits and '_' and must not start with a digit`,
+		},
+		{
+			name:      "namespace annotation",
+			namespace: map[string]string{defaultAnnotations.keyPrefix + "node.zone": "topology.kubernetes.io/zone"},
+			annotations: map[string]string{
+				defaultAnnotations.keyPrefix + "region": "topology.kubernetes.io/region",
+			},
+			allowed: true,
+			warnings: []string{
+				`environment variable names "NODE.ZONE" are invalid and ignored, they must consist of letters, digits and '_' and must not start with a digit`,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod0", Namespace: "default", Annotations: tt.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			i := newTestInjector(t, nil, []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "default", Annotations: tt.namespace}}})
+
+			resp := i.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation:   admissionv1.Create,
+				RequestKind: &metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Namespace:   "default",
+				Object:      rawObject(t, pod),
+			}})
+
+			assert.Equal(t, tt.allowed, resp.Allowed)
+			assert.Equal(t, tt.warnings, resp.Warnings)
+
+			if !tt.allowed {
+				assert.Equal(t, tt.message, resp.Result.Message)
+			}
+		})
+	}
+}
+
+func Test_handleBindingWarnings(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "node0",
+			Labels: map[string]string{"topology.kubernetes.io/zone": "zone-1"},
+		},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod0",
+			Namespace: "default",
+			Annotations: map[string]string{
+				defaultAnnotations.keyPrefix + "zone":   "topology.kubernetes.io/zone",
+				defaultAnnotations.keyPrefix + "region": "failure-domain.beta.kubernetes.io/region,topology.kubernetes.io/region",
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app"}},
+		},
+	}
+
+	i := newTestInjector(t, []*corev1.Node{node}, []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "default"}}}, pod)
+
+	binding := &corev1.Binding{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod0", Namespace: "default"},
+		Target:     corev1.ObjectReference{Kind: "Node", Name: "node0"},
+	}
+
+	resp := i.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation:   admissionv1.Create,
+		RequestKind: &metav1.GroupVersionKind{Version: "v1", Kind: "Binding"},
+		Namespace:   "default",
+		Object:      rawObject(t, binding),
+	}})
+
+	assert.True(t, resp.Allowed)
+	assert.Equal(t, []string{
+		`node "node0" has no label "failure-domain.beta.kubernetes.io/region or topology.kubernetes.io/region"`,
+	}, resp.Warnings)
+
+	patched, err := i.client.CoreV1().Pods("default").Get(context.Background(), "pod0", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "zone-1", patched.Labels["topology.kubernetes.io/zone"])
+}
+
+// recordedEvents returns the events recorded by the fake recorder
+func recordedEvents(i *NodeLabelsEnvInjector) []string {
+	recorder := i.recorder.(*record.FakeRecorder) //nolint: forcetypeassert
+
+	events := []string{}
+
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func Test_handlePodEvents(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "node0",
+			Labels: map[string]string{"topology.kubernetes.io/zone": "zone-1"},
+		},
+	}
+
+	// The pod has no UID at creation, the events are recorded at binding only
+	for _, tt := range []struct {
+		name string
+		pod  corev1.Pod
+	}{
+		{
+			name: "injected",
+			pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "pod0",
+					Annotations: map[string]string{defaultAnnotations.keyPrefix + "zone": "topology.kubernetes.io/zone"},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			},
+		},
+		{
+			name: "missing labels",
+			pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "pod0",
+					Annotations: map[string]string{
+						defaultAnnotations.keyPrefix + "zone": "topology.kubernetes.io/zone",
+						defaultAnnotations.keyPrefix + "rack": "topology.kubernetes.io/rack",
+					},
+				},
+				Spec: corev1.PodSpec{NodeName: "node0", Containers: []corev1.Container{{Name: "app"}}},
+			},
+		},
+		{
+			name: "generated name",
+			pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "pod-",
+					Annotations:  map[string]string{defaultAnnotations.keyPrefix + "zone": "topology.kubernetes.io/zone"},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newTestInjector(t, []*corev1.Node{node}, []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "default"}}})
+
+			resp := i.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation:   admissionv1.Create,
+				RequestKind: &metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Namespace:   "default",
+				Object:      rawObject(t, &tt.pod),
+			}})
+
+			assert.True(t, resp.Allowed)
+			assert.Empty(t, recordedEvents(i))
+		})
+	}
+}
+
+func Test_handleBindingEvents(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "node0",
+			Labels: map[string]string{"topology.kubernetes.io/zone": "zone-1"},
+		},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod0",
+			Namespace: "default",
+			Annotations: map[string]string{
+				defaultAnnotations.keyPrefix + "zone": "topology.kubernetes.io/zone",
+				defaultAnnotations.injectedEnvs:       "ZONE",
+			},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	conflict := apierrors.NewConflict(schema.GroupResource{Resource: "pods"}, "pod0", nil)
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "pod0", nil)
+
+	for _, tt := range []struct {
+		name     string
+		errs     []error
+		allowed  bool
+		expected []string
+	}{
+		{
+			name:     "applied",
+			allowed:  true,
+			expected: []string{"Normal NodeLabelsApplied Applied node node0 labels: topology.kubernetes.io/zone, environment variables: ZONE"},
+		},
+		{
+			name:     "queued",
+			errs:     []error{conflict},
+			allowed:  true,
+			expected: []string{},
+		},
+		{
+			name: "failed",
+			errs: []error{forbidden},
+			expected: []string{
+				"Warning NodeLabelsPatchFailed Failed to apply node node0 labels: " + forbidden.Error(),
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newTestInjector(t, []*corev1.Node{node}, []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "default"}}}, pod)
+
+			errs := tt.errs
+			i.client.(*fake.Clientset).PrependReactor("patch", "pods", func(_ k8stesting.Action) (bool, runtime.Object, error) { //nolint: forcetypeassert
+				if len(errs) == 0 {
+					return false, nil, nil
+				}
+
+				err := errs[0]
+				errs = errs[1:]
+
+				return true, nil, err
+			})
+
+			binding := &corev1.Binding{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod0", Namespace: "default"},
+				Target:     corev1.ObjectReference{Kind: "Node", Name: "node0"},
+			}
+
+			resp := i.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation:   admissionv1.Create,
+				RequestKind: &metav1.GroupVersionKind{Version: "v1", Kind: "Binding"},
+				Namespace:   "default",
+				Object:      rawObject(t, binding),
+			}})
+
+			assert.Equal(t, tt.allowed, resp.Allowed)
+			assert.Equal(t, tt.expected, recordedEvents(i))
+		})
+	}
+}
+
+func Test_handleProvenance(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node0",
+			Labels: map[string]string{
+				"topology.kubernetes.io/zone":   "zone-1",
+				"topology.kubernetes.io/region": "region-1",
+			},
+		},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod0",
+			Namespace: "default",
+			Annotations: map[string]string{
+				defaultAnnotations.keyPrefix + "zone": "topology.kubernetes.io/zone",
+				defaultAnnotations.jsonLabels:         "topology.kubernetes.io/region",
+			},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	provenance := `{"node":"node0","version":"v0.0.0-test","timestamp":"2025-01-02T03:04:05Z",` +
+		`"labels":["topology.kubernetes.io/zone"],"annotations":["node-labels-exporter.sinextra.dev/node-labels"]}`
+
+	for _, tt := range []struct {
+		name       string
+		nodeName   string
+		kind       string
+		provenance string
+		audit      map[string]string
+	}{
+		{
+			name: "pod",
+			kind: "Pod",
+			audit: map[string]string{
+				auditVersion: "v0.0.0-test",
+				auditEnvs:    defaultJSONEnv + ",ZONE",
+			},
+		},
+		{
+			name:       "pod with node name",
+			nodeName:   "node0",
+			kind:       "Pod",
+			provenance: provenance,
+			audit: map[string]string{
+				auditNode:        "node0",
+				auditVersion:     "v0.0.0-test",
+				auditLabels:      "topology.kubernetes.io/zone",
+				auditAnnotations: defaultAnnotations.nodeLabels,
+				auditEnvs:        defaultJSONEnv + ",ZONE",
+			},
+		},
+		{
+			name:       "binding",
+			kind:       "Binding",
+			provenance: provenance,
+			audit: map[string]string{
+				auditNode:        "node0",
+				auditVersion:     "v0.0.0-test",
+				auditLabels:      "topology.kubernetes.io/zone",
+				auditAnnotations: defaultAnnotations.nodeLabels,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := pod.DeepCopy()
+			p.Spec.NodeName = tt.nodeName
+
+			i := newTestInjector(t, []*corev1.Node{node}, []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "default"}}}, p)
+			i.now = func() time.Time { return time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+			var obj runtime.RawExtension
+
+			if tt.kind == "Binding" {
+				obj = rawObject(t, &corev1.Binding{
+					ObjectMeta: metav1.ObjectMeta{Name: "pod0", Namespace: "default"},
+					Target:     corev1.ObjectReference{Kind: "Node", Name: "node0"},
+				})
+			} else {
+				obj = rawObject(t, p)
+			}
+
+			resp := i.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation:   admissionv1.Create,
+				RequestKind: &metav1.GroupVersionKind{Version: "v1", Kind: tt.kind},
+				Namespace:   "default",
+				Object:      obj,
+			}})
+
+			assert.True(t, resp.Allowed)
+			assert.Equal(t, tt.audit, resp.AuditAnnotations)
+
+			var patched *corev1.Pod
+
+			if tt.kind == "Binding" {
+				var err error
+
+				patched, err = i.client.CoreV1().Pods("default").Get(context.Background(), "pod0", metav1.GetOptions{})
+				assert.NoError(t, err)
+			} else {
+				patched = patchedPod(t, obj, resp)
+			}
+
+			assert.Equal(t, tt.provenance, patched.Annotations[defaultAnnotations.provenance])
+		})
+	}
+}
+
+func Test_handleSkipsNodeLookup(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod0", Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeName: "node1", Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	for _, tt := range []struct {
+		name string
+		kind string
+		obj  any
+	}{
+		{
+			name: "pod with node name",
+			kind: "Pod",
+			obj:  pod,
+		},
+		{
+			name: "binding",
+			kind: "Binding",
+			obj: &corev1.Binding{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod0", Namespace: "default"},
+				Target:     corev1.ObjectReference{Kind: "Node", Name: "node1"},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newTestInjector(t, nil, []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "default"}}}, pod)
+
+			resp := i.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation:   admissionv1.Create,
+				RequestKind: &metav1.GroupVersionKind{Version: "v1", Kind: tt.kind},
+				Namespace:   "default",
+				Object:      rawObject(t, tt.obj),
+			}})
+
+			assert.True(t, resp.Allowed)
+
+			for _, action := range i.client.(*fake.Clientset).Actions() { //nolint: forcetypeassert
+				assert.NotEqual(t, "nodes", action.GetResource().Resource)
+			}
+		})
+	}
+}
+
+func Test_getNamespace(t *testing.T) {
+	cached := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	created := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-a"}}
+
+	i := newTestInjector(t, nil, []*corev1.Namespace{cached}, created)
+
+	ns, err := i.getNamespace(context.Background(), "default")
+	assert.NoError(t, err)
+	assert.Equal(t, cached, ns)
+
+	// The namespace created right before the pod is not in the cache yet
+	ns, err = i.getNamespace(context.Background(), "tenant-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant-a", ns.Name)
+
+	_, err = i.getNamespace(context.Background(), "unknown")
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func Test_getNode(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node0"}}
+
+	for _, tt := range []struct {
+		name    string
+		cached  bool
+		synced  bool
+		api     bool
+		result  string
+		wantErr bool
+	}{
+		{
+			name:   "cached",
+			cached: true,
+		},
+		{
+			name:   "informer",
+			synced: true,
+			result: nodeCacheMissInformer,
+		},
+		{
+			name:   "api",
+			api:    true,
+			result: nodeCacheMissAPI,
+		},
+		{
+			name:    "not found",
+			result:  nodeCacheMissFailed,
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var objects []runtime.Object
+			if tt.api {
+				objects = append(objects, node)
+			}
+
+			i := newTestInjector(t, nil, nil, objects...)
+			i.nodeCacheWait = 500 * time.Millisecond
+
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			i.nodeLister = corelisters.NewNodeLister(indexer)
+
+			if tt.cached {
+				assert.NoError(t, indexer.Add(node))
+			}
+
+			if tt.synced {
+				time.AfterFunc(150*time.Millisecond, func() { assert.NoError(t, indexer.Add(node)) })
+			}
+
+			var before float64
+			if tt.result != "" {
+				before = testutil.ToFloat64(nodeCacheMisses.WithLabelValues(tt.result))
+			}
+
+			got, err := i.getNode(context.Background(), "node0")
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "node0", got.Name)
+			}
+
+			if tt.result != "" {
+				assert.Equal(t, before+1, testutil.ToFloat64(nodeCacheMisses.WithLabelValues(tt.result)))
+			}
+		})
+	}
+}
+
+func Test_handleBindingStrict(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "node0",
+			Labels: map[string]string{"topology.kubernetes.io/zone": "zone-1"},
+		},
+	}
+
+	throttled := apierrors.NewTooManyRequests("throttled", 1)
+
+	for _, tt := range []struct {
+		name        string
+		required    string
+		annotations map[string]string
+		labels      map[string]string
+		deny        []string
+		err         error
+		allowed     bool
+		message     string
+		queued      bool
+	}{
+		{
+			name:     "required labels are present",
+			required: "topology.kubernetes.io/zone",
+			allowed:  true,
+		},
+		{
+			name:     "required label is missing",
+			required: "topology.kubernetes.io/zone,topology.kubernetes.io/region",
+			message:  `node "node0" has no required labels: topology.kubernetes.io/region`,
+		},
+		{
+			name:     "exported label is missing",
+			required: "*",
+			message:  `node "node0" has no required labels: sinextra.dev/rack`,
+		},
+		{
+			name:     "required label is denied",
+			required: "topology.kubernetes.io/zone",
+			deny:     []string{"topology.kubernetes.io/*"},
+			message:  `node "node0" has no required labels: topology.kubernetes.io/zone`,
+		},
+		{
+			name:        "required label is kept",
+			required:    "topology.kubernetes.io/zone",
+			annotations: map[string]string{defaultAnnotations.labelConflictPolicy: "Keep"},
+			labels:      map[string]string{"topology.kubernetes.io/zone": "zone-2"},
+			message:     `node "node0" has no required labels: topology.kubernetes.io/zone`,
+		},
+		{
+			name:        "required label has the node value",
+			required:    "topology.kubernetes.io/zone",
+			annotations: map[string]string{defaultAnnotations.labelConflictPolicy: "Keep"},
+			labels:      map[string]string{"topology.kubernetes.io/zone": "zone-1"},
+			allowed:     true,
+		},
+		{
+			name:     "patch failed",
+			required: "topology.kubernetes.io/zone",
+			err:      throttled,
+			message:  `failed to apply node "node0" labels to the pod: ` + throttled.Error(),
+		},
+		{
+			name:    "patch failed without required labels",
+			err:     throttled,
+			allowed: true,
+			queued:  true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "pod0",
+					Namespace: "default",
+					Labels:    tt.labels,
+					Annotations: map[string]string{
+						defaultAnnotations.keyPrefix + "zone": "topology.kubernetes.io/zone",
+					},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			maps.Copy(pod.Annotations, tt.annotations)
+
+			if tt.required != "" {
+				pod.Annotations[defaultAnnotations.requiredLabels] = tt.required
+			}
+
+			if tt.required == "*" {
+				pod.Annotations[defaultAnnotations.keyPrefix+"rack"] = "sinextra.dev/rack"
+			}
+
+			i := newTestInjector(t, []*corev1.Node{node}, []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "default"}}}, pod)
+			i.SetConfig(&config.Config{Labels: config.LabelPolicy{LabelFilter: config.LabelFilter{Deny: tt.deny}}})
+
+			if tt.err != nil {
+				i.client.(*fake.Clientset).PrependReactor("patch", "pods", func(_ k8stesting.Action) (bool, runtime.Object, error) { //nolint: forcetypeassert
+					return true, nil, tt.err
+				})
+			}
+
+			resp := i.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation:   admissionv1.Create,
+				RequestKind: &metav1.GroupVersionKind{Version: "v1", Kind: "Binding"},
+				Namespace:   "default",
+				Object: rawObject(t, &corev1.Binding{
+					ObjectMeta: metav1.ObjectMeta{Name: "pod0", Namespace: "default"},
+					Target:     corev1.ObjectReference{Kind: "Node", Name: "node0"},
+				}),
+			}})
+
+			assert.Equal(t, tt.allowed, resp.Allowed)
+			assert.Equal(t, tt.queued, i.patches.get(types.NamespacedName{Namespace: "default", Name: "pod0"}) != nil)
+
+			if !tt.allowed {
+				assert.Equal(t, tt.message, resp.Result.Message)
+				assert.Contains(t, recordedEvents(i), "Warning NodeLabelsBindingDenied Binding to node node0 is denied: "+tt.message)
+			}
+
+			i.patches.queue.ShutDown()
+		})
+	}
+}
+
+func Test_handleBindingStrictLookup(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		namespace map[string]string
+		pod       map[string]string
+		denied    bool
+		message   string
+	}{
+		{
+			name:    "node lookup failed",
+			pod:     map[string]string{defaultAnnotations.requiredLabels: "topology.kubernetes.io/zone"},
+			denied:  true,
+			message: `failed to get node node1: nodes "node1" not found`,
+		},
+		{
+			name:    "node lookup failed without required labels",
+			pod:     map[string]string{},
+			message: `failed to get node node1: nodes "node1" not found`,
+		},
+		{
+			name:      "pod lookup failed",
+			namespace: map[string]string{defaultAnnotations.requiredLabels: "topology.kubernetes.io/zone"},
+			denied:    true,
+			message:   `failed to get pod default/pod0: pods "pod0" not found`,
+		},
+		{
+			name:    "pod lookup failed without required labels",
+			message: `failed to get pod default/pod0: pods "pod0" not found`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var pods []runtime.Object
+
+			if tt.pod != nil {
+				tt.pod[defaultAnnotations.keyPrefix+"zone"] = "topology.kubernetes.io/zone"
+
+				pods = append(pods, &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: "pod0", Namespace: "default", UID: "uid0", Annotations: tt.pod},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+				})
+			}
+
+			i := newTestInjector(t, nil, []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "default", Annotations: tt.namespace}}}, pods...)
+			i.nodeCacheWait = 10 * time.Millisecond
+
+			resp := i.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation:   admissionv1.Create,
+				RequestKind: &metav1.GroupVersionKind{Version: "v1", Kind: "Binding"},
+				Namespace:   "default",
+				Object: rawObject(t, &corev1.Binding{
+					ObjectMeta: metav1.ObjectMeta{Name: "pod0", Namespace: "default", UID: "uid0"},
+					Target:     corev1.ObjectReference{Kind: "Node", Name: "node1"},
+				}),
+			}})
+
+			assert.False(t, resp.Allowed)
+			assert.Equal(t, tt.message, resp.Result.Message)
+
+			if tt.denied {
+				assert.Equal(t, int32(http.StatusForbidden), resp.Result.Code)
+				assert.Equal(t, []string{"Warning NodeLabelsBindingDenied Binding to node node1 is denied: " + tt.message}, recordedEvents(i))
+			} else {
+				assert.Equal(t, int32(http.StatusInternalServerError), resp.Result.Code)
+				assert.Empty(t, recordedEvents(i))
+			}
+		})
+	}
+}
+
+func Test_handlePodNamespaceConfigDefaults(t *testing.T) {
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				defaultAnnotations.config: `{"version":"v1alpha1","containers":["app"],"exports":[` +
+					`{"keys":["sinextra.dev/zone"],"env":"ZONE"},{"keys":["topology.kubernetes.io/region"],"env":"REGION"}]}`,
+			},
+		},
+	}
+
+	// The pod annotations win over the namespace configuration
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod0",
+			Namespace: "default",
+			Annotations: map[string]string{
+				defaultAnnotations.containers:         "sidecar",
+				defaultAnnotations.keyPrefix + "zone": "topology.kubernetes.io/zone",
+			},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}, {Name: "sidecar"}}},
+	}
+
+	i := newTestInjector(t, nil, []*corev1.Namespace{namespace})
+
+	raw := rawObject(t, pod)
+	resp := i.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation:   admissionv1.Create,
+		RequestKind: &metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Namespace:   "default",
+		Object:      raw,
+	}})
+
+	assert.True(t, resp.Allowed)
+	assert.Empty(t, resp.Warnings)
+
+	patched := patchedPod(t, raw, resp)
+
+	envs := func(c corev1.Container) map[string]string {
+		result := make(map[string]string)
+		for _, env := range c.Env {
+			result[env.Name] = env.ValueFrom.FieldRef.FieldPath
+		}
+
+		return result
+	}
+
+	assert.Empty(t, envs(patched.Spec.Containers[0]))
+	assert.Equal(t, map[string]string{
+		"ZONE":   "metadata.labels['topology.kubernetes.io/zone']",
+		"REGION": "metadata.labels['topology.kubernetes.io/region']",
+	}, envs(patched.Spec.Containers[1]))
+}
+
+func Test_handlePodNamespaceConfig(t *testing.T) {
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "default",
+			Annotations: map[string]string{defaultAnnotations.config: `{"version":"v1alpha1"}`},
+		},
+	}
+
+	for _, tt := range []struct {
+		name        string
+		annotations map[string]string
+		allowed     bool
+		warnings    []string
+		message     string
+	}{
+		{
+			name:        "invalid namespace config is ignored",
+			annotations: map[string]string{defaultAnnotations.keyPrefix + "zone": "topology.kubernetes.io/zone"},
+			allowed:     true,
+			warnings: []string{
+				`namespace "default" annotation "node-labels-exporter.sinextra.dev/config" is invalid and ignored: exports are empty`,
+			},
+		},
+		{
+			name: "pod config wins over the namespace one",
+			annotations: map[string]string{
+				defaultAnnotations.config: `{"version":"v1alpha1","exports":[{"keys":["topology.kubernetes.io/zone"],"env":"ZONE"}]}`,
+			},
+			allowed: true,
+			warnings: []string{
+				`namespace "default" annotation "node-labels-exporter.sinextra.dev/config" is invalid and ignored: exports are empty`,
+			},
+		},
+		{
+			name:        "invalid pod config is denied",
+			annotations: map[string]string{defaultAnnotations.config: `{"version":"v2"}`},
+			warnings: []string{
+				`namespace "default" annotation "node-labels-exporter.sinextra.dev/config" is invalid and ignored: exports are empty`,
+			},
+			message: `annotation "node-labels-exporter.sinextra.dev/config" is invalid: unsupported version "v2", expected "v1alpha1"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod0", Namespace: "default", Annotations: tt.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			i := newTestInjector(t, nil, []*corev1.Namespace{namespace})
+
+			resp := i.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation:   admissionv1.Create,
+				RequestKind: &metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Namespace:   "default",
+				Object:      rawObject(t, pod),
+			}})
+
+			assert.Equal(t, tt.allowed, resp.Allowed)
+			assert.Equal(t, tt.warnings, resp.Warnings)
+
+			if !tt.allowed {
+				assert.Equal(t, tt.message, resp.Result.Message)
+			}
+		})
+	}
+}
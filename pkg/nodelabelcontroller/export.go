@@ -0,0 +1,829 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelabelcontroller
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/sergelogvinov/node-labels-exporter/pkg/apis/nodelabels/v1alpha1"
+	"github.com/sergelogvinov/node-labels-exporter/pkg/config"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// labelExport describes a node label exported to the pod
+type labelExport struct {
+	// env is the environment variable name, empty for the pod label only exports
+	env string
+	// label is the pod label key, it is the first candidate node label key
+	label string
+	// keys is the ordered list of candidate node label keys, the first one present on the node wins
+	keys []string
+	// prefix is the node label key prefix, the export is expanded to every node label under the prefix
+	prefix string
+	// containers is the list of target container names or glob patterns of the environment variable,
+	// it replaces the spec containers, empty list means the spec containers
+	containers []string
+	// transform is applied to the node label value
+	transform labelTransform
+}
+
+// jsonExport describes node labels exported to the pod as a single JSON document
+type jsonExport struct {
+	// env is the environment variable name
+	env string
+	// annotation is the pod annotation the JSON document is stored in
+	annotation string
+	// keys is the list of node label keys
+	keys []string
+	// prefixes is the list of node label key prefixes
+	prefixes []string
+}
+
+// exportSpec describes everything exported to the pod
+type exportSpec struct {
+	// containers is the list of target container names or glob patterns, empty list means all containers
+	containers []string
+	// excludeContainers is the list of excluded container names or glob patterns
+	excludeContainers []string
+	// containerTypes is the list of target container types, empty list means all types
+	containerTypes []string
+	// exports is the list of node labels exported as pod labels and environment variables
+	exports []labelExport
+	// json is the node labels exported as a single JSON document
+	json *jsonExport
+	// allowed reports whether the node label may be exported, nil allows all labels
+	allowed func(key string) bool
+	// podLabel returns the pod label key of the node label key, nil keeps the node label key
+	podLabel func(key string) string
+	// ownedLabels is the pod annotation listing the pod labels written by the exporter instance, empty disables the tracking
+	ownedLabels string
+	// injectedEnvs is the pod annotation listing the injected environment variables, empty disables the tracking
+	injectedEnvs string
+	// envConflict is the action for the environment variables already defined in the containers
+	envConflict config.EnvConflictPolicy
+	// envPlacement is the position of the injected environment variables in the container
+	envPlacement config.EnvPlacement
+	// labelConflict is the action for the pod labels already set and not owned by the exporter
+	labelConflict config.LabelConflictPolicy
+	// required is the list of node label keys the node must have, "*" means all exported labels,
+	// the binding of the pod is denied if they are missing or the pod patch fails
+	required []string
+	// warnings is the list of problems found in the export definitions
+	warnings []string
+	// err is the structured configuration error, the pod is denied at creation
+	err error
+}
+
+// parseLabelKeys parses a comma-separated list of node label keys
+func parseLabelKeys(value string) []string {
+	keys := []string{}
+
+	for key := range strings.SplitSeq(value, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// labelPrefix returns the node label key prefix if the annotation value is a prefix selector, e.g. "feature.node.kubernetes.io/*"
+func labelPrefix(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, ",") {
+		return "", false
+	}
+
+	if prefix, ok := strings.CutSuffix(value, "*"); ok {
+		return prefix, true
+	}
+
+	return "", false
+}
+
+// inherited returns the namespace annotations inherited by pods
+func (a annotationNames) inherited(annotations map[string]string) map[string]string {
+	defaults := make(map[string]string)
+
+	for k, v := range annotations {
+		switch k {
+		case a.containers, a.excludeContainers, a.containerTypes, a.config, a.profile, a.envPrefix, a.envConflictPolicy, a.envPlacement,
+			a.labelConflictPolicy, a.requiredLabels, a.podLabelPrefix, a.jsonLabels, a.jsonEnv:
+			defaults[k] = v
+		default:
+			if strings.HasPrefix(k, a.keyPrefix) {
+				defaults[k] = v
+			}
+		}
+	}
+
+	return defaults
+}
+
+// mergeAnnotations returns the defaults overridden by the annotations
+func mergeAnnotations(defaults, annotations map[string]string) map[string]string {
+	merged := maps.Clone(defaults)
+	if merged == nil {
+		merged = make(map[string]string, len(annotations))
+	}
+
+	maps.Copy(merged, annotations)
+
+	return merged
+}
+
+// expandProfiles returns the annotations with the referenced export profiles expanded to injector annotations,
+// explicit annotations win over the profile ones. It also returns the names of unknown profiles.
+func (a annotationNames) expandProfiles(annotations map[string]string, profiles map[string]config.Profile) (map[string]string, []string) {
+	names := parseLabelKeys(annotations[a.profile])
+	if len(names) == 0 {
+		return annotations, nil
+	}
+
+	expanded := make(map[string]string, len(annotations))
+	unknown := []string{}
+
+	for _, name := range names {
+		profile, ok := profiles[name]
+		if !ok {
+			unknown = append(unknown, name)
+
+			continue
+		}
+
+		for k, v := range profile {
+			if _, ok := expanded[a.keyPrefix+k]; !ok {
+				expanded[a.keyPrefix+k] = v
+			}
+		}
+	}
+
+	maps.Copy(expanded, annotations)
+
+	return expanded, unknown
+}
+
+// exportSpec returns the export spec defined by the pod annotations
+func (a annotationNames) exportSpec(annotations map[string]string) exportSpec {
+	exports, warnings := a.exports(annotations)
+
+	spec := exportSpec{
+		containers:        parseLabelKeys(annotations[a.containers]),
+		excludeContainers: parseLabelKeys(annotations[a.excludeContainers]),
+		containerTypes:    parseLabelKeys(annotations[a.containerTypes]),
+		exports:           exports,
+		ownedLabels:       a.ownedLabels,
+		injectedEnvs:      a.injectedEnvs,
+		warnings:          warnings,
+	}
+
+	for _, pattern := range slices.Concat(spec.containers, spec.excludeContainers) {
+		if err := validateContainerPatterns([]string{pattern}); err != nil { //nolint: noinlineerr
+			spec.warnings = append(spec.warnings, err.Error())
+		}
+	}
+
+	for _, t := range spec.containerTypes {
+		if !isContainerType(t) {
+			spec.warnings = append(spec.warnings, fmt.Sprintf("annotation %q has unknown container type %q", a.containerTypes, t))
+		}
+	}
+
+	if e, ok := a.jsonExport(annotations); ok {
+		spec.json = &e
+	}
+
+	value, ok := annotations[a.config]
+	if !ok {
+		return spec
+	}
+
+	// The structured configuration wins, the other annotations are the shorthand for it
+	structured, err := podConfigExportSpec(value)
+	if err != nil {
+		spec.err = fmt.Errorf("annotation %q is invalid: %v", a.config, err)
+
+		return spec
+	}
+
+	structured.ownedLabels = a.ownedLabels
+	structured.injectedEnvs = a.injectedEnvs
+
+	return structured.withDefaults(spec)
+}
+
+// exports returns the node label exports defined by the pod annotations, sorted by annotation key.
+// The annotation value may start with the explicit environment variable name, e.g. "NodeZone=topology.kubernetes.io/zone".
+// It also returns the warnings about the invalid annotations.
+func (a annotationNames) exports(annotations map[string]string) ([]labelExport, []string) {
+	exports := []labelExport{}
+
+	var warnings []string
+
+	for _, k := range slices.Sorted(maps.Keys(annotations)) {
+		env, ok := a.annotationKeyToEnvName(k)
+		if !ok {
+			continue
+		}
+
+		value := annotations[k]
+		if name, keys, ok := strings.Cut(value, "="); ok {
+			env, value = strings.TrimSpace(name), keys
+			if env == "" {
+				warnings = append(warnings, fmt.Sprintf("annotation %q has an empty environment variable name", k))
+
+				continue
+			}
+		}
+
+		if prefix, ok := labelPrefix(value); ok {
+			if prefix != "" {
+				exports = append(exports, labelExport{env: env, prefix: prefix})
+			}
+
+			continue
+		}
+
+		keys := parseLabelKeys(value)
+		if len(keys) == 0 {
+			continue
+		}
+
+		exports = append(exports, labelExport{env: env, label: keys[0], keys: keys})
+	}
+
+	return exports, warnings
+}
+
+// addExport adds the node label export of the sink to the spec, the environment variable name is used as is.
+// The template carries the per-export options: target containers and value transform.
+func (s *exportSpec) addExport(export v1alpha1.NodeLabelExport, template labelExport) error {
+	keys := parseLabelKeys(strings.Join(export.Keys, ","))
+	if len(keys) == 0 {
+		return fmt.Errorf("keys are empty")
+	}
+
+	prefix, isPrefix := "", false
+	if len(keys) == 1 {
+		prefix, isPrefix = strings.CutSuffix(keys[0], "*")
+	}
+
+	if isPrefix && prefix == "" {
+		return fmt.Errorf("prefix selector is empty")
+	}
+
+	e := template
+	e.env, e.label, e.keys, e.prefix = "", "", nil, ""
+
+	switch export.Sink {
+	case v1alpha1.ExportSinkEnv, "":
+		if export.Env == "" {
+			return fmt.Errorf("env is required for the %s sink", v1alpha1.ExportSinkEnv)
+		}
+
+		e.env = export.Env
+	case v1alpha1.ExportSinkLabel:
+	case v1alpha1.ExportSinkJSON:
+		if s.json == nil {
+			s.json = &jsonExport{env: defaultJSONEnv}
+		}
+
+		if export.Env != "" {
+			s.json.env = export.Env
+		}
+
+		for _, key := range keys {
+			if prefix, ok := strings.CutSuffix(key, "*"); ok {
+				s.json.prefixes = append(s.json.prefixes, prefix)
+			} else {
+				s.json.keys = append(s.json.keys, key)
+			}
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown sink %q", export.Sink)
+	}
+
+	if isPrefix {
+		e.prefix = prefix
+	} else {
+		e.label, e.keys = keys[0], keys
+	}
+
+	s.exports = append(s.exports, e)
+
+	return nil
+}
+
+// isEmpty reports whether the spec exports nothing
+func (s exportSpec) isEmpty() bool {
+	return len(s.exports) == 0 && s.json == nil
+}
+
+// isContainerType reports whether the container type is known
+func isContainerType(t string) bool {
+	switch t {
+	case containerTypeContainers, containerTypeInit, containerTypeSidecars, containerTypeEphemeral:
+		return true
+	}
+
+	return false
+}
+
+// selectsContainer reports whether the container of the type is the injection target,
+// the container names are matched against the glob patterns
+func (s exportSpec) selectsContainer(name, containerType string) bool {
+	if len(s.containerTypes) > 0 && !slices.Contains(s.containerTypes, containerType) {
+		return false
+	}
+
+	match := func(pattern string) bool {
+		ok, err := path.Match(pattern, name)
+
+		return err == nil && ok
+	}
+
+	if len(s.containers) > 0 && !slices.ContainsFunc(s.containers, match) {
+		return false
+	}
+
+	return !slices.ContainsFunc(s.excludeContainers, match)
+}
+
+// hasLabelPrefix reports whether any of the exports or the JSON export is a prefix selector
+func (s exportSpec) hasLabelPrefix() bool {
+	return slices.ContainsFunc(s.exports, func(e labelExport) bool { return e.prefix != "" }) || (s.json != nil && len(s.json.prefixes) > 0)
+}
+
+// isAllowed reports whether the node label may be exported
+func (s exportSpec) isAllowed(key string) bool {
+	return s.allowed == nil || s.allowed(key)
+}
+
+// denied returns the sorted list of requested node label keys which may not be exported,
+// the prefix selectors are expanded against nodeLabelKeys
+func (s exportSpec) denied(nodeLabelKeys []string) []string {
+	if s.allowed == nil {
+		return nil
+	}
+
+	requested := make(map[string]struct{})
+	prefixes := []string{}
+
+	for _, e := range s.exports {
+		if e.prefix != "" {
+			prefixes = append(prefixes, e.prefix)
+		}
+
+		for _, key := range e.keys {
+			requested[key] = struct{}{}
+		}
+	}
+
+	if s.json != nil {
+		prefixes = append(prefixes, s.json.prefixes...)
+
+		for _, key := range s.json.keys {
+			requested[key] = struct{}{}
+		}
+	}
+
+	for _, key := range nodeLabelKeys {
+		if slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(key, p) }) {
+			requested[key] = struct{}{}
+		}
+	}
+
+	denied := []string{}
+
+	for _, key := range slices.Sorted(maps.Keys(requested)) {
+		if !s.allowed(key) {
+			denied = append(denied, key)
+		}
+	}
+
+	return denied
+}
+
+// missing returns the requested node label keys not set on the node, the fallback chains are joined by " or ".
+// The denied node label keys are skipped.
+func (s exportSpec) missing(node *corev1.Node) []string {
+	missing := []string{}
+
+	for _, e := range s.expand(slices.Collect(maps.Keys(node.Labels))) {
+		if _, ok := e.value(node); !ok {
+			missing = append(missing, strings.Join(e.keys, " or "))
+		}
+	}
+
+	if s.json != nil {
+		for _, key := range s.json.keys {
+			if _, ok := node.Labels[key]; !ok && s.isAllowed(key) {
+				missing = append(missing, key)
+			}
+		}
+	}
+
+	slices.Sort(missing)
+
+	return slices.Compact(missing)
+}
+
+// strict reports whether the pod is in the strict mode
+func (s exportSpec) strict() bool {
+	return len(s.required) > 0
+}
+
+// missingRequired returns the sorted list of the required node label keys not exported to the pod labels,
+// the denied keys and the pod labels kept by the conflict policy are missing,
+// the required key of the fallback chain is satisfied by any key of the chain
+func (s exportSpec) missingRequired(node *corev1.Node, pod *corev1.Pod) []string {
+	exports := s.expand(slices.Collect(maps.Keys(node.Labels)))
+
+	exported := func(e labelExport) bool {
+		value, ok := e.value(node)
+
+		return ok && pod.Labels[e.label] == value
+	}
+
+	if slices.Contains(s.required, requiredAllLabels) {
+		missing := s.missing(node)
+
+		for _, e := range exports {
+			if _, ok := e.value(node); ok && !exported(e) {
+				missing = append(missing, strings.Join(e.keys, " or "))
+			}
+		}
+
+		slices.Sort(missing)
+
+		return slices.Compact(missing)
+	}
+
+	missing := []string{}
+
+	for _, key := range s.required {
+		if _, ok := node.Labels[key]; ok && s.json != nil && s.isAllowed(key) && s.json.includes(key) {
+			continue
+		}
+
+		if !slices.ContainsFunc(exports, func(e labelExport) bool { return slices.Contains(e.keys, key) && exported(e) }) {
+			missing = append(missing, key)
+		}
+	}
+
+	slices.Sort(missing)
+
+	return slices.Compact(missing)
+}
+
+// withDefaults returns the spec merged with the defaults, the spec wins over the defaults
+func (s exportSpec) withDefaults(defaults exportSpec) exportSpec {
+	merged := exportSpec{
+		containers:        s.containers,
+		excludeContainers: s.excludeContainers,
+		containerTypes:    s.containerTypes,
+		exports:           slices.Clone(s.exports),
+		json:              s.json,
+		allowed:           s.allowed,
+		podLabel:          s.podLabel,
+		ownedLabels:       s.ownedLabels,
+		injectedEnvs:      s.injectedEnvs,
+		warnings:          slices.Concat(s.warnings, defaults.warnings),
+		err:               s.err,
+	}
+
+	if merged.err == nil {
+		merged.err = defaults.err
+	}
+
+	if len(merged.containers) == 0 {
+		merged.containers = defaults.containers
+	}
+
+	if len(merged.excludeContainers) == 0 {
+		merged.excludeContainers = defaults.excludeContainers
+	}
+
+	if len(merged.containerTypes) == 0 {
+		merged.containerTypes = defaults.containerTypes
+	}
+
+	if merged.podLabel == nil {
+		merged.podLabel = defaults.podLabel
+	}
+
+	if merged.ownedLabels == "" {
+		merged.ownedLabels = defaults.ownedLabels
+	}
+
+	if merged.injectedEnvs == "" {
+		merged.injectedEnvs = defaults.injectedEnvs
+	}
+
+	if merged.allowed == nil {
+		merged.allowed = defaults.allowed
+	}
+
+	for _, e := range defaults.exports {
+		if !slices.ContainsFunc(merged.exports, e.overriddenBy) {
+			merged.exports = append(merged.exports, e)
+		}
+	}
+
+	switch {
+	case merged.json == nil:
+		merged.json = defaults.json
+	case defaults.json != nil:
+		merged.json = &jsonExport{
+			env:        merged.json.env,
+			annotation: merged.json.annotation,
+			keys:       append(slices.Clone(merged.json.keys), defaults.json.keys...),
+			prefixes:   append(slices.Clone(merged.json.prefixes), defaults.json.prefixes...),
+		}
+	}
+
+	return merged
+}
+
+// withEnvPrefix returns the spec with the prefix added to all environment variable names
+func (s exportSpec) withEnvPrefix(prefix string) exportSpec {
+	if prefix == "" {
+		return s
+	}
+
+	prefixed := s
+	prefixed.exports = make([]labelExport, 0, len(s.exports))
+
+	for _, e := range s.exports {
+		if e.env != "" {
+			e.env = prefix + e.env
+		}
+
+		prefixed.exports = append(prefixed.exports, e)
+	}
+
+	if s.json != nil {
+		e := *s.json
+		e.env = prefix + e.env
+		prefixed.json = &e
+	}
+
+	return prefixed
+}
+
+// envNames returns the environment variable names of the exports
+func (s exportSpec) envNames() []string {
+	names := []string{}
+
+	for _, e := range s.exports {
+		if e.env != "" {
+			names = append(names, e.env)
+		}
+	}
+
+	if s.json != nil {
+		names = append(names, s.json.env)
+	}
+
+	return names
+}
+
+// withValidEnvNames returns the spec without the exports with invalid environment variable names.
+// The invalid names of the pod own annotations are the spec error, the pod is denied at creation.
+// The other invalid names come from the namespace, profiles or policies, they are dropped with a warning.
+func (s exportSpec) withValidEnvNames(own []string) exportSpec {
+	valid := s
+	valid.exports = []labelExport{}
+
+	invalid := []string{}
+
+	for _, e := range s.exports {
+		if e.env != "" && !isEnvName(e.env) {
+			invalid = append(invalid, e.env)
+
+			continue
+		}
+
+		valid.exports = append(valid.exports, e)
+	}
+
+	if s.json != nil && !isEnvName(s.json.env) {
+		invalid = append(invalid, s.json.env)
+		valid.json = nil
+	}
+
+	inherited := slices.DeleteFunc(slices.Clone(invalid), func(name string) bool { return slices.Contains(own, name) })
+	if len(inherited) > 0 {
+		valid.warnings = append(slices.Clone(valid.warnings), invalidEnvNamesWarning(inherited))
+	}
+
+	if len(inherited) < len(invalid) && valid.err == nil {
+		valid.err = invalidEnvNamesError(slices.DeleteFunc(invalid, func(name string) bool { return !slices.Contains(own, name) }))
+	}
+
+	return valid
+}
+
+// withSinks returns the spec without the exports of the disabled sinks
+func (s exportSpec) withSinks(enabled func(sink v1alpha1.ExportSink) bool) exportSpec {
+	filtered := s
+	filtered.exports = slices.DeleteFunc(slices.Clone(s.exports), func(e labelExport) bool {
+		if e.env == "" {
+			return !enabled(v1alpha1.ExportSinkLabel)
+		}
+
+		return !enabled(v1alpha1.ExportSinkEnv)
+	})
+
+	if !enabled(v1alpha1.ExportSinkJSON) {
+		filtered.json = nil
+	}
+
+	return filtered
+}
+
+// expand returns the exports with the prefix selectors expanded against nodeLabelKeys,
+// the env name is the export env name joined with the normalized label key suffix.
+// Explicit exports always win over expanded ones, other env name collisions are resolved
+// in favor of the first export and then the first label key.
+// The denied node label keys are removed, exports without allowed keys are dropped.
+// The pod label keys are mapped, the first export wins the pod label written from different node labels.
+func (s exportSpec) expand(nodeLabelKeys []string) []labelExport {
+	exports := []labelExport{}
+	expanded := []labelExport{}
+
+	for _, e := range s.exports {
+		if e.prefix != "" {
+			expanded = append(expanded, expandLabelPrefix(e, nodeLabelKeys)...)
+
+			continue
+		}
+
+		if s.allowed != nil {
+			e.keys = slices.DeleteFunc(slices.Clone(e.keys), func(key string) bool { return !s.allowed(key) })
+			if len(e.keys) == 0 {
+				continue
+			}
+		}
+
+		e.label = s.podLabelKey(e.label)
+
+		if !slices.ContainsFunc(exports, e.podLabelTakenBy) {
+			exports = append(exports, e)
+		}
+	}
+
+	for _, e := range expanded {
+		if !s.isAllowed(e.label) {
+			continue
+		}
+
+		e.label = s.podLabelKey(e.label)
+
+		if !slices.ContainsFunc(exports, e.overriddenBy) && !slices.ContainsFunc(exports, e.podLabelTakenBy) {
+			exports = append(exports, e)
+		}
+	}
+
+	return exports
+}
+
+// podLabelKey returns the pod label key of the node label key
+func (s exportSpec) podLabelKey(key string) string {
+	if s.podLabel == nil {
+		return key
+	}
+
+	return s.podLabel(key)
+}
+
+// podLabelTakenBy reports whether the other export writes the same pod label from different node labels or with a different transform
+func (e labelExport) podLabelTakenBy(other labelExport) bool {
+	return other.label == e.label && (!slices.Equal(other.keys, e.keys) || other.transform != e.transform)
+}
+
+// overriddenBy reports whether the export is overridden by the other one,
+// exports with the same env name or the same pod label only exports are the same
+func (e labelExport) overriddenBy(other labelExport) bool {
+	if e.env == "" {
+		return other.env == "" && other.label == e.label && other.prefix == e.prefix
+	}
+
+	return other.env == e.env
+}
+
+// expandLabelPrefix returns the exports for every node label key under the template prefix, sorted by label key.
+// The env name is empty for the pod label only exports, the other options are copied from the template.
+func expandLabelPrefix(template labelExport, nodeLabelKeys []string) []labelExport {
+	exports := []labelExport{}
+
+	if template.prefix == "" {
+		return exports
+	}
+
+	for _, key := range slices.Sorted(slices.Values(nodeLabelKeys)) {
+		suffix, ok := strings.CutPrefix(key, template.prefix)
+		if !ok || suffix == "" {
+			continue
+		}
+
+		e := template
+		e.label, e.keys, e.prefix = key, []string{key}, ""
+
+		if e.env != "" {
+			e.env = template.env + "_" + suffixEnvName(suffix)
+		}
+
+		exports = append(exports, e)
+	}
+
+	return exports
+}
+
+// jsonExport returns the JSON export defined by the pod annotations
+func (a annotationNames) jsonExport(annotations map[string]string) (jsonExport, bool) {
+	e := jsonExport{env: defaultJSONEnv, annotation: a.nodeLabels}
+
+	for _, key := range parseLabelKeys(annotations[a.jsonLabels]) {
+		if prefix, ok := strings.CutSuffix(key, "*"); ok {
+			if prefix != "" {
+				e.prefixes = append(e.prefixes, prefix)
+			}
+
+			continue
+		}
+
+		e.keys = append(e.keys, key)
+	}
+
+	if len(e.keys) == 0 && len(e.prefixes) == 0 {
+		return jsonExport{}, false
+	}
+
+	if env := strings.TrimSpace(annotations[a.jsonEnv]); env != "" {
+		e.env = env
+	}
+
+	return e, true
+}
+
+// value returns the JSON document of the selected node labels, the labels not allowed by the filter are skipped
+func (e jsonExport) value(node *corev1.Node, allowed func(key string) bool) (string, error) {
+	labels := make(map[string]string)
+
+	for k, v := range node.Labels {
+		if allowed != nil && !allowed(k) {
+			continue
+		}
+
+		if e.includes(k) {
+			labels[k] = v
+		}
+	}
+
+	// encoding/json sorts map keys, the document is stable for the same node labels
+	doc, err := json.Marshal(labels)
+	if err != nil {
+		return "", err
+	}
+
+	return string(doc), nil
+}
+
+// includes reports whether the node label key is a part of the JSON document
+func (e jsonExport) includes(key string) bool {
+	return slices.Contains(e.keys, key) || slices.ContainsFunc(e.prefixes, func(p string) bool { return strings.HasPrefix(key, p) })
+}
+
+// value returns the transformed value of the first candidate label key present on the node
+func (e labelExport) value(node *corev1.Node) (string, bool) {
+	for _, key := range e.keys {
+		if v, ok := node.Labels[key]; ok {
+			return e.transform.apply(v), true
+		}
+	}
+
+	return "", false
+}
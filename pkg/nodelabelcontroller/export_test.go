@@ -0,0 +1,684 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelabelcontroller
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sergelogvinov/node-labels-exporter/pkg/apis/nodelabels/v1alpha1"
+	"github.com/sergelogvinov/node-labels-exporter/pkg/config"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_parseLabelKeys(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		value    string
+		expected []string
+	}{
+		{
+			name:     "empty value",
+			value:    "",
+			expected: []string{},
+		},
+		{
+			name:     "single key",
+			value:    "topology.kubernetes.io/zone",
+			expected: []string{"topology.kubernetes.io/zone"},
+		},
+		{
+			name:     "fallback chain with spaces",
+			value:    "topology.kubernetes.io/zone, failure-domain.beta.kubernetes.io/zone,,sinextra.dev/zone ",
+			expected: []string{"topology.kubernetes.io/zone", "failure-domain.beta.kubernetes.io/zone", "sinextra.dev/zone"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, parseLabelKeys(tt.value))
+		})
+	}
+}
+
+func Test_labelPrefix(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		value    string
+		expected string
+		ok       bool
+	}{
+		{
+			name:  "label key",
+			value: "topology.kubernetes.io/zone",
+		},
+		{
+			name:     "prefix selector",
+			value:    "feature.node.kubernetes.io/*",
+			expected: "feature.node.kubernetes.io/",
+			ok:       true,
+		},
+		{
+			name:  "prefix selector in fallback chain",
+			value: "topology.kubernetes.io/zone,feature.node.kubernetes.io/*",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, ok := labelPrefix(tt.value)
+			assert.Equal(t, tt.expected, prefix)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+}
+
+func Test_exportSpecExpand(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		annotations   map[string]string
+		nodeLabelKeys []string
+		expected      []labelExport
+	}{
+		{
+			name:        "no annotations",
+			annotations: nil,
+			expected:    []labelExport{},
+		},
+		{
+			name: "sorted exports with fallback chain",
+			annotations: map[string]string{
+				defaultAnnotations.containers:              "app",
+				defaultAnnotations.keyPrefix + "zone":      "topology.kubernetes.io/zone,failure-domain.beta.kubernetes.io/zone",
+				defaultAnnotations.keyPrefix + "node-pool": "node.kubernetes.io/instance-type",
+				defaultAnnotations.keyPrefix + "empty":     " , ",
+			},
+			expected: []labelExport{
+				{
+					env:   "NODE_POOL",
+					label: "node.kubernetes.io/instance-type",
+					keys:  []string{"node.kubernetes.io/instance-type"},
+				},
+				{
+					env:   "ZONE",
+					label: "topology.kubernetes.io/zone",
+					keys:  []string{"topology.kubernetes.io/zone", "failure-domain.beta.kubernetes.io/zone"},
+				},
+			},
+		},
+		{
+			name: "prefix selector",
+			annotations: map[string]string{
+				defaultAnnotations.keyPrefix + "feature": "feature.node.kubernetes.io/*",
+				defaultAnnotations.keyPrefix + "all":     "*",
+			},
+			nodeLabelKeys: []string{
+				"kubernetes.io/hostname",
+				"feature.node.kubernetes.io/cpu-cpuid.AVX",
+				"feature.node.kubernetes.io/",
+				"feature.node.kubernetes.io/kernel-version.major",
+			},
+			expected: []labelExport{
+				{
+					env:   "FEATURE_CPU_CPUID_AVX",
+					label: "feature.node.kubernetes.io/cpu-cpuid.AVX",
+					keys:  []string{"feature.node.kubernetes.io/cpu-cpuid.AVX"},
+				},
+				{
+					env:   "FEATURE_KERNEL_VERSION_MAJOR",
+					label: "feature.node.kubernetes.io/kernel-version.major",
+					keys:  []string{"feature.node.kubernetes.io/kernel-version.major"},
+				},
+			},
+		},
+		{
+			name: "explicit env names",
+			annotations: map[string]string{
+				defaultAnnotations.keyPrefix + "zone":    "NodeZone=topology.kubernetes.io/zone,sinextra.dev/zone",
+				defaultAnnotations.keyPrefix + "feature": " node_feature = feature.node.kubernetes.io/*",
+			},
+			nodeLabelKeys: []string{
+				"feature.node.kubernetes.io/cpu-cpuid.AVX",
+			},
+			expected: []labelExport{
+				{
+					env:   "NodeZone",
+					label: "topology.kubernetes.io/zone",
+					keys:  []string{"topology.kubernetes.io/zone", "sinextra.dev/zone"},
+				},
+				{
+					env:   "node_feature_CPU_CPUID_AVX",
+					label: "feature.node.kubernetes.io/cpu-cpuid.AVX",
+					keys:  []string{"feature.node.kubernetes.io/cpu-cpuid.AVX"},
+				},
+			},
+		},
+		{
+			name: "prefix selector collisions",
+			annotations: map[string]string{
+				defaultAnnotations.keyPrefix + "node":      "node.sinextra.dev/*",
+				defaultAnnotations.keyPrefix + "node-rack": "topology.kubernetes.io/rack",
+			},
+			nodeLabelKeys: []string{
+				"node.sinextra.dev/rack",
+				"node.sinextra.dev/row_a",
+				"node.sinextra.dev/row-a",
+			},
+			expected: []labelExport{
+				{
+					env:   "NODE_RACK",
+					label: "topology.kubernetes.io/rack",
+					keys:  []string{"topology.kubernetes.io/rack"},
+				},
+				{
+					env:   "NODE_ROW_A",
+					label: "node.sinextra.dev/row-a",
+					keys:  []string{"node.sinextra.dev/row-a"},
+				},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, defaultAnnotations.exportSpec(tt.annotations).expand(tt.nodeLabelKeys))
+		})
+	}
+}
+
+func Test_exportSpecAllowed(t *testing.T) {
+	filter := config.LabelFilter{
+		Allow: []string{"topology.kubernetes.io/*", "feature.node.kubernetes.io/*", "kubernetes.io/hostname"},
+		Deny:  []string{"topology.kubernetes.io/rack", "feature.node.kubernetes.io/secret-*"},
+	}
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node0",
+			Labels: map[string]string{
+				"topology.kubernetes.io/zone":              "zone-1",
+				"topology.kubernetes.io/rack":              "rack-1",
+				"feature.node.kubernetes.io/cpu-cpuid.AVX": "true",
+				"feature.node.kubernetes.io/secret-tag":    "asset-1",
+				"sinextra.dev/tenant":                      "tenant-1",
+			},
+		},
+	}
+
+	spec := defaultAnnotations.exportSpec(map[string]string{
+		defaultAnnotations.keyPrefix + "zone":    "sinextra.dev/zone,topology.kubernetes.io/zone",
+		defaultAnnotations.keyPrefix + "rack":    "topology.kubernetes.io/rack",
+		defaultAnnotations.keyPrefix + "feature": "feature.node.kubernetes.io/*",
+		defaultAnnotations.keyPrefix + "tenant":  "sinextra.dev/tenant",
+		defaultAnnotations.jsonLabels:            "topology.kubernetes.io/*,sinextra.dev/tenant",
+	})
+	spec.allowed = filter.Allowed
+
+	nodeLabelKeys := slices.Sorted(maps.Keys(node.Labels))
+
+	assert.Equal(t, []labelExport{
+		{
+			env:   "ZONE",
+			label: "sinextra.dev/zone",
+			keys:  []string{"topology.kubernetes.io/zone"},
+		},
+		{
+			env:   "FEATURE_CPU_CPUID_AVX",
+			label: "feature.node.kubernetes.io/cpu-cpuid.AVX",
+			keys:  []string{"feature.node.kubernetes.io/cpu-cpuid.AVX"},
+		},
+	}, spec.expand(nodeLabelKeys))
+
+	assert.Equal(t, []string{
+		"feature.node.kubernetes.io/secret-tag",
+		"sinextra.dev/tenant",
+		"sinextra.dev/zone",
+		"topology.kubernetes.io/rack",
+	}, spec.denied(nodeLabelKeys))
+
+	doc, err := spec.json.value(node, spec.allowed)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"topology.kubernetes.io/zone":"zone-1"}`, doc)
+}
+
+func Test_jsonExport(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node0",
+			Labels: map[string]string{
+				"topology.kubernetes.io/region":                "region-1",
+				"topology.kubernetes.io/zone":                  "zone-1",
+				"feature.node.kubernetes.io/cpu-cpuid.AVX":     "true",
+				"feature.node.kubernetes.io/kernel-version.os": "linux",
+			},
+		},
+	}
+
+	for _, tt := range []struct {
+		name        string
+		annotations map[string]string
+		ok          bool
+		expected    jsonExport
+		doc         string
+	}{
+		{
+			name:        "no annotations",
+			annotations: map[string]string{},
+		},
+		{
+			name: "empty prefix only",
+			annotations: map[string]string{
+				defaultAnnotations.jsonLabels: "*",
+			},
+		},
+		{
+			name: "keys and prefixes",
+			annotations: map[string]string{
+				defaultAnnotations.jsonLabels: "topology.kubernetes.io/zone,feature.node.kubernetes.io/*,sinextra.dev/rack",
+			},
+			ok: true,
+			expected: jsonExport{
+				env:        defaultJSONEnv,
+				annotation: defaultAnnotations.nodeLabels,
+				keys:       []string{"topology.kubernetes.io/zone", "sinextra.dev/rack"},
+				prefixes:   []string{"feature.node.kubernetes.io/"},
+			},
+			doc: `{"feature.node.kubernetes.io/cpu-cpuid.AVX":"true","feature.node.kubernetes.io/kernel-version.os":"linux","topology.kubernetes.io/zone":"zone-1"}`,
+		},
+		{
+			name: "custom env name",
+			annotations: map[string]string{
+				defaultAnnotations.jsonLabels: "topology.kubernetes.io/region",
+				defaultAnnotations.jsonEnv:    "NODE_INFO",
+			},
+			ok: true,
+			expected: jsonExport{
+				env:        "NODE_INFO",
+				annotation: defaultAnnotations.nodeLabels,
+				keys:       []string{"topology.kubernetes.io/region"},
+			},
+			doc: `{"topology.kubernetes.io/region":"region-1"}`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			e, ok := defaultAnnotations.jsonExport(tt.annotations)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expected, e)
+
+			if ok {
+				doc, err := e.value(node, nil)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.doc, doc)
+			}
+		})
+	}
+}
+
+func Test_expandProfiles(t *testing.T) {
+	profiles := map[string]config.Profile{
+		"topology": {
+			"region": "topology.kubernetes.io/region",
+			"zone":   "topology.kubernetes.io/zone",
+		},
+		"instance": {
+			"zone":          "sinextra.dev/zone",
+			"instance-type": "node.kubernetes.io/instance-type",
+		},
+	}
+
+	for _, tt := range []struct {
+		name        string
+		annotations map[string]string
+		expected    map[string]string
+		unknown     []string
+	}{
+		{
+			name: "no profile",
+			annotations: map[string]string{
+				defaultAnnotations.keyPrefix + "zone": "topology.kubernetes.io/zone",
+			},
+			expected: map[string]string{
+				defaultAnnotations.keyPrefix + "zone": "topology.kubernetes.io/zone",
+			},
+		},
+		{
+			name: "profiles with explicit annotation",
+			annotations: map[string]string{
+				defaultAnnotations.profile:              "topology, instance,unknown",
+				defaultAnnotations.keyPrefix + "region": "sinextra.dev/region",
+			},
+			expected: map[string]string{
+				defaultAnnotations.profile:                     "topology, instance,unknown",
+				defaultAnnotations.keyPrefix + "region":        "sinextra.dev/region",
+				defaultAnnotations.keyPrefix + "zone":          "topology.kubernetes.io/zone",
+				defaultAnnotations.keyPrefix + "instance-type": "node.kubernetes.io/instance-type",
+			},
+			unknown: []string{"unknown"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations, unknown := defaultAnnotations.expandProfiles(tt.annotations, profiles)
+			assert.Equal(t, tt.expected, annotations)
+			assert.Equal(t, tt.unknown, unknown)
+		})
+	}
+}
+
+func Test_inherited(t *testing.T) {
+	namespace := map[string]string{
+		defaultAnnotations.containers:         "app",
+		defaultAnnotations.keyPrefix + "zone": "topology.kubernetes.io/zone",
+		defaultAnnotations.nodeLabels:         "{}",
+		defaultAnnotations.namespaceDefaults:  "false",
+		"kubernetes.io/description":           "test",
+	}
+
+	pod := map[string]string{
+		defaultAnnotations.keyPrefix + "zone":   "sinextra.dev/zone",
+		defaultAnnotations.keyPrefix + "region": "topology.kubernetes.io/region",
+	}
+
+	defaults := defaultAnnotations.inherited(namespace)
+	assert.Equal(t, map[string]string{
+		defaultAnnotations.containers:         "app",
+		defaultAnnotations.keyPrefix + "zone": "topology.kubernetes.io/zone",
+	}, defaults)
+
+	assert.Equal(t, map[string]string{
+		defaultAnnotations.containers:           "app",
+		defaultAnnotations.keyPrefix + "zone":   "sinextra.dev/zone",
+		defaultAnnotations.keyPrefix + "region": "topology.kubernetes.io/region",
+	}, mergeAnnotations(defaults, pod))
+
+	assert.Equal(t, pod, mergeAnnotations(nil, pod))
+}
+
+func Test_exportSpecMissingRequired(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node0",
+			Labels: map[string]string{
+				"topology.kubernetes.io/zone": "zone-1",
+				"kubernetes.io/hostname":      "node0",
+			},
+		},
+	}
+
+	spec := defaultAnnotations.exportSpec(map[string]string{
+		defaultAnnotations.keyPrefix + "zone":   "topology.kubernetes.io/zone",
+		defaultAnnotations.keyPrefix + "region": "failure-domain.beta.kubernetes.io/region,topology.kubernetes.io/region",
+		defaultAnnotations.keyPrefix + "host":   "sinextra.dev/hostname,kubernetes.io/hostname",
+	})
+
+	for _, tt := range []struct {
+		name      string
+		required  []string
+		denied    []string
+		podLabels map[string]string
+		policy    config.LabelConflictPolicy
+		json      *jsonExport
+		strict    bool
+		expected  []string
+	}{
+		{
+			name:     "not strict",
+			expected: []string{},
+		},
+		{
+			name:     "present",
+			required: []string{"topology.kubernetes.io/zone"},
+			strict:   true,
+			expected: []string{},
+		},
+		{
+			name:     "missing",
+			required: []string{"topology.kubernetes.io/zone", "topology.kubernetes.io/region", "sinextra.dev/rack"},
+			strict:   true,
+			expected: []string{"sinextra.dev/rack", "topology.kubernetes.io/region"},
+		},
+		{
+			name:     "not exported",
+			required: []string{"kubernetes.io/hostname", "kubernetes.io/os"},
+			strict:   true,
+			expected: []string{"kubernetes.io/os"},
+		},
+		{
+			name:     "fallback label is present",
+			required: []string{"sinextra.dev/hostname"},
+			strict:   true,
+			expected: []string{},
+		},
+		{
+			name:     "denied",
+			required: []string{"topology.kubernetes.io/zone"},
+			denied:   []string{"topology.kubernetes.io/zone"},
+			strict:   true,
+			expected: []string{"topology.kubernetes.io/zone"},
+		},
+		{
+			name:      "kept pod label",
+			required:  []string{"topology.kubernetes.io/zone"},
+			podLabels: map[string]string{"topology.kubernetes.io/zone": "zone-2"},
+			policy:    config.LabelConflictKeep,
+			strict:    true,
+			expected:  []string{"topology.kubernetes.io/zone"},
+		},
+		{
+			name:      "pod label has the node value",
+			required:  []string{"topology.kubernetes.io/zone"},
+			podLabels: map[string]string{"topology.kubernetes.io/zone": "zone-1"},
+			policy:    config.LabelConflictKeep,
+			strict:    true,
+			expected:  []string{},
+		},
+		{
+			name:      "kept pod label is in the JSON document",
+			required:  []string{"topology.kubernetes.io/zone"},
+			podLabels: map[string]string{"topology.kubernetes.io/zone": "zone-2"},
+			policy:    config.LabelConflictKeep,
+			json:      &jsonExport{annotation: "node-labels-exporter.sinextra.dev/json", prefixes: []string{"topology.kubernetes.io/"}},
+			strict:    true,
+			expected:  []string{},
+		},
+		{
+			name:     "all exported labels",
+			required: []string{requiredAllLabels},
+			strict:   true,
+			expected: []string{"failure-domain.beta.kubernetes.io/region or topology.kubernetes.io/region"},
+		},
+		{
+			name:      "all exported labels with kept pod label",
+			required:  []string{requiredAllLabels},
+			podLabels: map[string]string{"topology.kubernetes.io/zone": "zone-2"},
+			policy:    config.LabelConflictKeep,
+			strict:    true,
+			expected:  []string{"failure-domain.beta.kubernetes.io/region or topology.kubernetes.io/region", "topology.kubernetes.io/zone"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := spec
+			s.required = tt.required
+			s.labelConflict = tt.policy
+			s.json = tt.json
+
+			if len(tt.denied) > 0 {
+				s.allowed = func(key string) bool { return !slices.Contains(tt.denied, key) }
+			}
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod0", Labels: maps.Clone(tt.podLabels)}}
+			setLabelsToPod(node, pod, s)
+
+			assert.Equal(t, tt.strict, s.strict())
+			assert.Equal(t, tt.expected, s.missingRequired(node, pod))
+		})
+	}
+}
+
+func Test_exportSpecWithDefaults(t *testing.T) {
+	spec := exportSpec{
+		exports: []labelExport{
+			{env: "ZONE", label: "topology.kubernetes.io/zone", keys: []string{"topology.kubernetes.io/zone"}},
+		},
+		json: &jsonExport{env: "NODE", keys: []string{"topology.kubernetes.io/zone"}},
+	}
+
+	defaults := exportSpec{
+		containers: []string{"app"},
+		exports: []labelExport{
+			{env: "ZONE", label: "sinextra.dev/zone", keys: []string{"sinextra.dev/zone"}},
+			{env: "REGION", label: "topology.kubernetes.io/region", keys: []string{"topology.kubernetes.io/region"}},
+			{label: "topology.kubernetes.io/region", keys: []string{"topology.kubernetes.io/region"}},
+		},
+		json: &jsonExport{env: defaultJSONEnv, prefixes: []string{"feature.node.kubernetes.io/"}},
+	}
+
+	assert.Equal(t, exportSpec{
+		containers: []string{"app"},
+		exports: []labelExport{
+			{env: "ZONE", label: "topology.kubernetes.io/zone", keys: []string{"topology.kubernetes.io/zone"}},
+			{env: "REGION", label: "topology.kubernetes.io/region", keys: []string{"topology.kubernetes.io/region"}},
+			{label: "topology.kubernetes.io/region", keys: []string{"topology.kubernetes.io/region"}},
+		},
+		json: &jsonExport{env: "NODE", keys: []string{"topology.kubernetes.io/zone"}, prefixes: []string{"feature.node.kubernetes.io/"}},
+	}, spec.withDefaults(defaults))
+
+	assert.Equal(t, defaults, exportSpec{}.withDefaults(defaults))
+	assert.True(t, exportSpec{}.isEmpty())
+	assert.False(t, spec.isEmpty())
+}
+
+func Test_exportSpecWithSinks(t *testing.T) {
+	spec := exportSpec{
+		exports: []labelExport{
+			{env: "ZONE", label: "topology.kubernetes.io/zone", keys: []string{"topology.kubernetes.io/zone"}},
+			{label: "topology.kubernetes.io/region", keys: []string{"topology.kubernetes.io/region"}},
+		},
+		json: &jsonExport{env: defaultJSONEnv, prefixes: []string{"feature.node.kubernetes.io/"}},
+	}
+
+	for _, tt := range []struct {
+		name     string
+		sinks    []v1alpha1.ExportSink
+		expected exportSpec
+	}{
+		{
+			name:     "all sinks",
+			expected: spec,
+		},
+		{
+			name:  "env only",
+			sinks: []v1alpha1.ExportSink{v1alpha1.ExportSinkEnv},
+			expected: exportSpec{
+				exports: []labelExport{
+					{env: "ZONE", label: "topology.kubernetes.io/zone", keys: []string{"topology.kubernetes.io/zone"}},
+				},
+			},
+		},
+		{
+			name:  "label and json",
+			sinks: []v1alpha1.ExportSink{v1alpha1.ExportSinkLabel, v1alpha1.ExportSinkJSON},
+			expected: exportSpec{
+				exports: []labelExport{
+					{label: "topology.kubernetes.io/region", keys: []string{"topology.kubernetes.io/region"}},
+				},
+				json: spec.json,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Sinks: tt.sinks}
+
+			assert.Equal(t, tt.expected, spec.withSinks(cfg.SinkEnabled))
+		})
+	}
+}
+
+func Test_newAnnotationNames(t *testing.T) {
+	ann := newAnnotationNames("platform.example.com")
+
+	spec := ann.exportSpec(map[string]string{
+		"platform.example.com/containers":                  "app",
+		"injector.platform.example.com/zone":               "topology.kubernetes.io/zone",
+		"platform.example.com/json-labels":                 "topology.kubernetes.io/region",
+		defaultAnnotations.keyPrefix + "region":            "topology.kubernetes.io/region",
+		"injector.node-labels-exporter.sinextra.dev/other": "topology.kubernetes.io/region",
+	})
+
+	assert.Equal(t, exportSpec{
+		containers:        []string{"app"},
+		excludeContainers: []string{},
+		containerTypes:    []string{},
+		exports: []labelExport{
+			{env: "ZONE", label: "topology.kubernetes.io/zone", keys: []string{"topology.kubernetes.io/zone"}},
+		},
+		json:         &jsonExport{env: defaultJSONEnv, annotation: "platform.example.com/node-labels", keys: []string{"topology.kubernetes.io/region"}},
+		ownedLabels:  "platform.example.com/owned-labels",
+		injectedEnvs: "platform.example.com/injected-envs",
+	}, spec)
+
+	assert.Equal(t, defaultAnnotations, newAnnotationNames(""))
+}
+
+func Test_exportSpecEnvNames(t *testing.T) {
+	spec := defaultAnnotations.exportSpec(map[string]string{
+		defaultAnnotations.keyPrefix + "zone":    "topology.kubernetes.io/zone",
+		defaultAnnotations.keyPrefix + "1region": "topology.kubernetes.io/region",
+		defaultAnnotations.keyPrefix + "rack":    "node.rack=topology.kubernetes.io/rack",
+		defaultAnnotations.keyPrefix + "host":    "=kubernetes.io/hostname",
+		defaultAnnotations.jsonLabels:            "topology.kubernetes.io/*",
+	})
+
+	assert.Equal(t, exportSpec{
+		containers:        []string{},
+		excludeContainers: []string{},
+		containerTypes:    []string{},
+		exports: []labelExport{
+			{env: "ZONE", label: "topology.kubernetes.io/zone", keys: []string{"topology.kubernetes.io/zone"}},
+		},
+		json:         &jsonExport{env: defaultJSONEnv, annotation: defaultAnnotations.nodeLabels, prefixes: []string{"topology.kubernetes.io/"}},
+		ownedLabels:  defaultAnnotations.ownedLabels,
+		injectedEnvs: defaultAnnotations.injectedEnvs,
+		warnings: []string{
+			`annotation "injector.node-labels-exporter.sinextra.dev/host" has an empty environment variable name`,
+		},
+		err: invalidEnvNamesError([]string{"1REGION", "node.rack"}),
+	}, spec.withValidEnvNames(spec.envNames()))
+
+	prefixed := spec.withEnvPrefix("NLE_")
+	prefixed = prefixed.withValidEnvNames(prefixed.envNames())
+
+	assert.Equal(t, []labelExport{
+		{env: "NLE_1REGION", label: "topology.kubernetes.io/region", keys: []string{"topology.kubernetes.io/region"}},
+		{env: "NLE_ZONE", label: "topology.kubernetes.io/zone", keys: []string{"topology.kubernetes.io/zone"}},
+	}, prefixed.exports)
+	assert.Equal(t, "NLE_"+defaultJSONEnv, prefixed.json.env)
+	assert.Equal(t, []string{
+		`annotation "injector.node-labels-exporter.sinextra.dev/host" has an empty environment variable name`,
+	}, prefixed.warnings)
+	assert.EqualError(t, prefixed.err, `environment variable names "NLE_node.rack" are invalid, they must consist of letters, digits and '_' and must not start with a digit`)
+
+	// The names not defined by the pod annotations are dropped with a warning
+	inherited := spec.withValidEnvNames([]string{"node.rack"})
+
+	assert.Equal(t, []labelExport{
+		{env: "ZONE", label: "topology.kubernetes.io/zone", keys: []string{"topology.kubernetes.io/zone"}},
+	}, inherited.exports)
+	assert.Equal(t, []string{
+		`annotation "injector.node-labels-exporter.sinextra.dev/host" has an empty environment variable name`,
+		`environment variable names "1REGION" are invalid and ignored, they must consist of letters, digits and '_' and must not start with a digit`,
+	}, inherited.warnings)
+	assert.Equal(t, invalidEnvNamesError([]string{"node.rack"}), inherited.err)
+}
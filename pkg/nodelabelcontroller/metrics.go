@@ -0,0 +1,69 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelabelcontroller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "node_labels_exporter"
+
+const (
+	// nodeCacheMissInformer is the cache miss resolved by the informer during the wait
+	nodeCacheMissInformer = "informer"
+	// nodeCacheMissAPI is the cache miss resolved by the direct API request
+	nodeCacheMissAPI = "api"
+	// nodeCacheMissFailed is the cache miss not resolved
+	nodeCacheMissFailed = "failed"
+)
+
+// nodeCacheMisses counts the nodes not found in the informer cache by the result of the fallback
+var nodeCacheMisses = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "node_cache_misses_total",
+		Help:      "Number of nodes not found in the informer cache, by the fallback result: informer, api or failed.",
+	},
+	[]string{"result"},
+)
+
+var (
+	// patchQueueDepth is the number of the pod patches waiting in the retry queue
+	patchQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "patch_queue_depth",
+		Help:      "Number of the failed pod patches waiting in the retry queue.",
+	})
+	// patchQueueRetries counts the failed retries of the pod patches
+	patchQueueRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "patch_queue_retries_total",
+		Help:      "Number of the failed pod patch retries requeued with the backoff.",
+	})
+	// patchQueueGiveUps counts the pod patches dropped from the retry queue
+	patchQueueGiveUps = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "patch_queue_give_ups_total",
+		Help:      "Number of the pod patches dropped from the retry queue after the retries or a permanent error.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(nodeCacheMisses, patchQueueDepth, patchQueueRetries, patchQueueGiveUps)
+}
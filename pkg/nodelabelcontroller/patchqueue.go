@@ -0,0 +1,155 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelabelcontroller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// patchQueueBaseDelay is the delay of the first retry of the failed pod patch
+	patchQueueBaseDelay = time.Second
+	// patchQueueMaxDelay is the maximum delay between the retries of the failed pod patch
+	patchQueueMaxDelay = 5 * time.Minute
+	// patchQueueMaxRetries is the number of retries of the failed pod patch before giving up
+	patchQueueMaxRetries = 10
+)
+
+// podPatch is the pod patch of the binding waiting for the retry
+type podPatch struct {
+	pod   *corev1.Pod
+	node  string
+	patch []byte
+	// retries is the number of the queued patch attempts made, the failed binding attempt is not counted
+	retries int
+}
+
+// patchQueue keeps retrying the failed pod patches with the exponential backoff,
+// the latest patch of the pod replaces the queued one
+type patchQueue struct {
+	queue      workqueue.TypedRateLimitingInterface[types.NamespacedName]
+	maxRetries int
+
+	mu      sync.Mutex
+	patches map[types.NamespacedName]*podPatch
+}
+
+func newPatchQueue(rateLimiter workqueue.TypedRateLimiter[types.NamespacedName], maxRetries int) *patchQueue {
+	return &patchQueue{
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(rateLimiter, workqueue.TypedRateLimitingQueueConfig[types.NamespacedName]{
+			Name: "pod_patches",
+		}),
+		maxRetries: maxRetries,
+		patches:    make(map[types.NamespacedName]*podPatch),
+	}
+}
+
+func (q *patchQueue) add(p *podPatch) {
+	key := types.NamespacedName{Namespace: p.pod.Namespace, Name: p.pod.Name}
+
+	q.mu.Lock()
+	q.patches[key] = p
+	patchQueueDepth.Set(float64(len(q.patches)))
+	q.mu.Unlock()
+
+	q.queue.AddRateLimited(key)
+}
+
+func (q *patchQueue) get(key types.NamespacedName) *podPatch {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.patches[key]
+}
+
+// done removes the patch from the queue if it was not replaced by the newer one
+func (q *patchQueue) done(key types.NamespacedName, p *podPatch) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.patches[key] == p {
+		delete(q.patches, key)
+		q.queue.Forget(key)
+	}
+
+	patchQueueDepth.Set(float64(len(q.patches)))
+}
+
+// RunPatchQueue retries the failed binding patches until the context is done
+func (i *NodeLabelsEnvInjector) RunPatchQueue(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		i.patches.queue.ShutDown()
+	}()
+
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		for i.processPatch(ctx) {
+		}
+	}, time.Second)
+
+	return nil
+}
+
+// processPatch applies the next queued patch, it returns false when the queue is shut down
+func (i *NodeLabelsEnvInjector) processPatch(ctx context.Context) bool {
+	key, shutdown := i.patches.queue.Get()
+	if shutdown {
+		return false
+	}
+
+	defer i.patches.queue.Done(key)
+
+	p := i.patches.get(key)
+	if p == nil {
+		return true
+	}
+
+	_, err := i.client.CoreV1().Pods(key.Namespace).Patch(ctx, key.Name, types.StrategicMergePatchType, p.patch, metav1.PatchOptions{})
+	p.retries++
+
+	if err == nil {
+		i.log.Info("Patched pod from the retry queue", "namespace", key.Namespace, "name", key.Name, "node", p.node)
+		i.patches.done(key, p)
+		i.event(p.pod, corev1.EventTypeNormal, eventReasonLabelsApplied, "Applied node %s labels after %d retries", p.node, p.retries)
+
+		return true
+	}
+
+	if !isRetriable(err) || p.retries >= i.patches.maxRetries {
+		i.log.Error(err, "Failed to patch pod, giving up", "namespace", key.Namespace, "name", key.Name, "node", p.node)
+		patchQueueGiveUps.Inc()
+		i.patches.done(key, p)
+		i.event(p.pod, corev1.EventTypeWarning, eventReasonPatchFailed, "Failed to apply node %s labels: %v", p.node, err)
+
+		return true
+	}
+
+	i.log.V(1).Info("Failed to patch pod, retrying", "namespace", key.Namespace, "name", key.Name, "node", p.node, "error", err.Error())
+	patchQueueRetries.Inc()
+	i.patches.queue.AddRateLimited(key)
+	i.event(p.pod, corev1.EventTypeWarning, eventReasonPatchRetry, "Node labels patch failed, retry %d of %d is queued", p.retries+1, i.patches.maxRetries)
+
+	return true
+}
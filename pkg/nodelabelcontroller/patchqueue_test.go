@@ -0,0 +1,183 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelabelcontroller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/workqueue"
+
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func Test_processPatch(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod0", Namespace: "default"}}
+	conflict := apierrors.NewConflict(schema.GroupResource{Resource: "pods"}, "pod0", nil)
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "pod0", nil)
+
+	for _, tt := range []struct {
+		name     string
+		errs     []error
+		patched  bool
+		attempts int
+		retries  float64
+		giveUps  float64
+		expected []string
+	}{
+		{
+			name:     "patched",
+			patched:  true,
+			attempts: 1,
+			expected: []string{
+				"Normal NodeLabelsApplied Applied node node0 labels after 1 retries",
+			},
+		},
+		{
+			name:     "retried",
+			errs:     []error{conflict},
+			patched:  true,
+			attempts: 2,
+			retries:  1,
+			expected: []string{
+				"Warning NodeLabelsPatchRetry Node labels patch failed, retry 2 of 3 is queued",
+				"Normal NodeLabelsApplied Applied node node0 labels after 2 retries",
+			},
+		},
+		{
+			name:     "permanent error",
+			errs:     []error{forbidden},
+			attempts: 1,
+			giveUps:  1,
+			expected: []string{
+				"Warning NodeLabelsPatchFailed Failed to apply node node0 labels: " + forbidden.Error(),
+			},
+		},
+		{
+			name:     "retries exceeded",
+			errs:     []error{conflict, conflict, conflict},
+			attempts: 3,
+			retries:  2,
+			giveUps:  1,
+			expected: []string{
+				"Warning NodeLabelsPatchRetry Node labels patch failed, retry 2 of 3 is queued",
+				"Warning NodeLabelsPatchRetry Node labels patch failed, retry 3 of 3 is queued",
+				"Warning NodeLabelsPatchFailed Failed to apply node node0 labels: " + conflict.Error(),
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newTestInjector(t, nil, nil, pod)
+			i.patches = newPatchQueue(workqueue.NewTypedItemExponentialFailureRateLimiter[types.NamespacedName](time.Millisecond, 10*time.Millisecond), 3)
+
+			errs := tt.errs
+			attempts := 0
+			i.client.(*fake.Clientset).PrependReactor("patch", "pods", func(_ k8stesting.Action) (bool, runtime.Object, error) { //nolint: forcetypeassert
+				attempts++
+
+				if len(errs) == 0 {
+					return false, nil, nil
+				}
+
+				err := errs[0]
+				errs = errs[1:]
+
+				return true, nil, err
+			})
+
+			retries := testutil.ToFloat64(patchQueueRetries)
+			giveUps := testutil.ToFloat64(patchQueueGiveUps)
+
+			i.patches.add(&podPatch{pod: pod, node: "node0", patch: []byte(`{"metadata":{"labels":{"topology.kubernetes.io/zone":"zone-1"}}}`)})
+			assert.Equal(t, 1.0, testutil.ToFloat64(patchQueueDepth))
+
+			for i.patches.get(types.NamespacedName{Namespace: "default", Name: "pod0"}) != nil {
+				assert.True(t, i.processPatch(context.Background()))
+			}
+
+			assert.Equal(t, tt.attempts, attempts)
+			assert.Equal(t, 0.0, testutil.ToFloat64(patchQueueDepth))
+			assert.Equal(t, tt.retries, testutil.ToFloat64(patchQueueRetries)-retries)
+			assert.Equal(t, tt.giveUps, testutil.ToFloat64(patchQueueGiveUps)-giveUps)
+			assert.Equal(t, tt.expected, recordedEvents(i))
+
+			patched, err := i.client.CoreV1().Pods("default").Get(context.Background(), "pod0", metav1.GetOptions{})
+			assert.NoError(t, err)
+
+			if tt.patched {
+				assert.Equal(t, "zone-1", patched.Labels["topology.kubernetes.io/zone"])
+			} else {
+				assert.Empty(t, patched.Labels)
+			}
+		})
+	}
+}
+
+func Test_handleBindingQueued(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "node0",
+			Labels: map[string]string{"topology.kubernetes.io/zone": "zone-1"},
+		},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pod0",
+			Namespace:   "default",
+			Annotations: map[string]string{defaultAnnotations.keyPrefix + "zone": "topology.kubernetes.io/zone"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	i := newTestInjector(t, []*corev1.Node{node}, []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "default"}}}, pod)
+	i.patches = newPatchQueue(workqueue.NewTypedItemExponentialFailureRateLimiter[types.NamespacedName](time.Millisecond, 10*time.Millisecond), 3)
+
+	throttled := apierrors.NewTooManyRequests("throttled", 1)
+	i.client.(*fake.Clientset).PrependReactor("patch", "pods", func(_ k8stesting.Action) (bool, runtime.Object, error) { //nolint: forcetypeassert
+		return true, nil, throttled
+	})
+
+	resp := i.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation:   admissionv1.Create,
+		RequestKind: &metav1.GroupVersionKind{Version: "v1", Kind: "Binding"},
+		Namespace:   "default",
+		Object: rawObject(t, &corev1.Binding{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod0", Namespace: "default"},
+			Target:     corev1.ObjectReference{Kind: "Node", Name: "node0"},
+		}),
+	}})
+
+	assert.True(t, resp.Allowed)
+	assert.Equal(t, []string{"pod patch failed, it is queued for retry: " + throttled.Error()}, resp.Warnings)
+	assert.NotNil(t, i.patches.get(types.NamespacedName{Namespace: "default", Name: "pod0"}))
+
+	i.patches.queue.ShutDown()
+}
@@ -18,129 +18,453 @@ package nodelabelcontroller
 
 import (
 	"fmt"
+	"maps"
+	"path"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/sergelogvinov/node-labels-exporter/pkg/config"
+
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+var (
+	envNameReplacer       = strings.NewReplacer("-", "_")
+	suffixEnvNameReplacer = strings.NewReplacer("-", "_", ".", "_", "/", "_")
+	envNameRegexp         = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
 )
 
-func annotationKeyToEnvName(key string) (string, bool) {
-	if env, ok := strings.CutPrefix(key, annKeyPrefix); ok {
-		return strings.ReplaceAll(strings.ToUpper(env), "-", "_"), true
+// envName normalizes the explicit annotation key to the environment variable name
+func envName(name string) string {
+	return envNameReplacer.Replace(strings.ToUpper(name))
+}
+
+// suffixEnvName normalizes the node label key suffix of the prefix selector to the environment variable name
+func suffixEnvName(suffix string) string {
+	return suffixEnvNameReplacer.Replace(strings.ToUpper(suffix))
+}
+
+// isEnvName reports whether the name is a valid C identifier
+func isEnvName(name string) bool {
+	return envNameRegexp.MatchString(name)
+}
+
+// invalidEnvNamesError returns the error of the invalid environment variable names
+func invalidEnvNamesError(names []string) error {
+	return fmt.Errorf("environment variable names %s are invalid, they must consist of letters, digits and '_' and must not start with a digit",
+		quoteNames(names))
+}
+
+// invalidEnvNamesWarning returns the warning of the ignored invalid environment variable names
+func invalidEnvNamesWarning(names []string) string {
+	return fmt.Sprintf("environment variable names %s are invalid and ignored, they must consist of letters, digits and '_' and must not start with a digit",
+		quoteNames(names))
+}
+
+// quoteNames returns the comma-separated list of the quoted names
+func quoteNames(names []string) string {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, strconv.Quote(name))
+	}
+
+	return strings.Join(quoted, ", ")
+}
+
+func (a annotationNames) annotationKeyToEnvName(key string) (string, bool) {
+	if env, ok := strings.CutPrefix(key, a.keyPrefix); ok {
+		return envName(env), true
 	}
 
 	return "", false
 }
 
-func getEnvsFromNode(node *corev1.Node, pod *corev1.Pod) map[string]string {
-	envs := make(map[string]string)
+// getEnvsFromNode returns the environment variables with the literal values of the node labels
+func getEnvsFromNode(node *corev1.Node, spec exportSpec) ([]envTarget, error) {
+	targets := []envTarget{}
 
-	for k, v := range pod.Annotations {
-		if label, ok := node.Labels[v]; ok {
-			if env, ok := annotationKeyToEnvName(k); ok {
-				envs[env] = label
-			}
+	for _, e := range spec.expand(slices.Collect(maps.Keys(node.Labels))) {
+		if label, ok := e.value(node); ok && e.env != "" {
+			targets = append(targets, envTarget{containers: e.containers, env: corev1.EnvVar{Name: e.env, Value: label}})
 		}
 	}
 
-	return envs
+	if spec.json != nil {
+		doc, err := spec.json.value(node, spec.allowed)
+		if err != nil {
+			return nil, err
+		}
+
+		targets = append(targets, envTarget{env: corev1.EnvVar{Name: spec.json.env, Value: doc}})
+	}
+
+	return targets, nil
 }
 
-func setEnvsToPod(pod *corev1.Pod, envs map[string]string) {
-	for i := range pod.Spec.Containers {
-		c := pod.Spec.Containers[i]
+// labelConflict is a pod label already set and not owned by the exporter
+type labelConflict struct {
+	label  string
+	action config.LabelConflictPolicy
+}
 
-		if c.Name != "" {
-			for key, value := range envs {
-				updated := false
+func (c labelConflict) String() string {
+	switch c.action {
+	case config.LabelConflictKeep:
+		return fmt.Sprintf("pod label %q is already set, it was kept", c.label)
+	case config.LabelConflictFail:
+		return fmt.Sprintf("pod label %q is already set", c.label)
+	default:
+		return fmt.Sprintf("pod label %q is already set, it was overwritten", c.label)
+	}
+}
 
-				for j, env := range c.Env {
-					if env.Name == key {
-						pod.Spec.Containers[i].Env[j].Value = value
-						pod.Spec.Containers[i].Env[j].ValueFrom = nil
+// setLabelsToPod sets the node labels to the pod labels and returns the written ones.
+// The pod labels already set with other values and not owned by the exporter are handled by the policy,
+// only the Overwrite policy (default) replaces them.
+func setLabelsToPod(node *corev1.Node, pod *corev1.Pod, spec exportSpec) (map[string]string, []labelConflict) {
+	labels := make(map[string]string)
+	owned := ownedLabels(pod, spec)
 
-						updated = true
+	var conflicts []labelConflict
 
-						break
-					}
-				}
+	policy := spec.labelConflict
+	if policy == "" {
+		policy = config.LabelConflictOverwrite
+	}
 
-				if !updated {
-					pod.Spec.Containers[i].Env = append(pod.Spec.Containers[i].Env, corev1.EnvVar{Name: key, Value: value})
-				}
+	for _, e := range spec.expand(slices.Collect(maps.Keys(node.Labels))) {
+		label, ok := e.value(node)
+		if !ok {
+			continue
+		}
+
+		if current, ok := pod.Labels[e.label]; ok && !slices.Contains(owned, e.label) {
+			if current == label {
+				continue
+			}
+
+			conflicts = append(conflicts, labelConflict{label: e.label, action: policy})
+
+			if policy != config.LabelConflictOverwrite {
+				continue
 			}
 		}
+
+		if pod.Labels == nil {
+			pod.Labels = make(map[string]string)
+		}
+
+		pod.Labels[e.label] = label
+		labels[e.label] = label
+	}
+
+	if len(labels) > 0 && spec.ownedLabels != "" {
+		setAnnotationKeysToPod(pod, spec.ownedLabels, slices.Collect(maps.Keys(labels)))
 	}
+
+	return labels, conflicts
 }
 
-func setLabelsToPod(node *corev1.Node, pod *corev1.Pod) map[string]string {
-	labels := make(map[string]string)
+// podLabelConflicts returns the exported pod labels already set and not owned by the exporter,
+// the prefix selectors are expanded against nodeLabelKeys
+func podLabelConflicts(pod *corev1.Pod, spec exportSpec, nodeLabelKeys []string) []labelConflict {
+	var conflicts []labelConflict
 
-	for k, v := range pod.Annotations {
-		if strings.HasPrefix(k, annKeyPrefix) {
-			if label, ok := node.Labels[v]; ok {
-				pod.Labels[v] = label
-				labels[v] = label
-			}
+	owned := ownedLabels(pod, spec)
+
+	for _, e := range spec.expand(nodeLabelKeys) {
+		if _, ok := pod.Labels[e.label]; ok && !slices.Contains(owned, e.label) {
+			conflicts = append(conflicts, labelConflict{label: e.label, action: spec.labelConflict})
 		}
 	}
 
-	return labels
+	return conflicts
 }
 
-func setEnvValueFromToPod(pod *corev1.Pod) bool {
-	envs := make(map[string]string)
-	containers := []string{}
+// ownedLabels returns the pod labels owned by the exporter
+func ownedLabels(pod *corev1.Pod, spec exportSpec) []string {
+	if spec.ownedLabels == "" {
+		return []string{}
+	}
 
-	for k, v := range pod.Annotations {
-		if k == annContainers {
-			containers = strings.Split(v, ",")
+	return parseLabelKeys(pod.Annotations[spec.ownedLabels])
+}
 
-			continue
+// setAnnotationKeysToPod adds the keys to the sorted comma-separated list in the pod annotation
+func setAnnotationKeysToPod(pod *corev1.Pod, annotation string, keys []string) {
+	owned := parseLabelKeys(pod.Annotations[annotation])
+
+	for _, key := range keys {
+		if !slices.Contains(owned, key) {
+			owned = append(owned, key)
 		}
+	}
+
+	slices.Sort(owned)
+
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+
+	pod.Annotations[annotation] = strings.Join(owned, ",")
+}
+
+func setLabelsJSONToPod(node *corev1.Node, pod *corev1.Pod, spec exportSpec) (string, error) {
+	if spec.json == nil {
+		return "", nil
+	}
+
+	doc, err := spec.json.value(node, spec.allowed)
+	if err != nil {
+		return "", err
+	}
+
+	if pod.Annotations[spec.json.annotation] == doc {
+		return "", nil
+	}
+
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+
+	pod.Annotations[spec.json.annotation] = doc
+
+	return doc, nil
+}
+
+// unknownContainers returns the container name patterns matching none of the pod containers
+func unknownContainers(pod *corev1.Pod, patterns []string) []string {
+	names := []string{}
+
+	for _, c := range slices.Concat(pod.Spec.InitContainers, pod.Spec.Containers) {
+		names = append(names, c.Name)
+	}
+
+	for _, c := range pod.Spec.EphemeralContainers {
+		names = append(names, c.Name)
+	}
 
-		if env, ok := annotationKeyToEnvName(k); ok {
-			envs[env] = v
+	unknown := []string{}
+
+	for _, pattern := range patterns {
+		if !slices.ContainsFunc(names, func(name string) bool {
+			ok, err := path.Match(pattern, name)
+
+			return err == nil && ok
+		}) {
+			unknown = append(unknown, pattern)
 		}
 	}
 
-	if len(envs) == 0 {
-		return false
+	return unknown
+}
+
+// envTarget is the environment variable injected to the selected containers
+type envTarget struct {
+	// containers replaces the spec containers, empty list means the spec containers
+	containers []string
+	env        corev1.EnvVar
+}
+
+// setEnvValueFromToPod sets the environment variables referencing the pod labels and the JSON document annotation,
+// the values are resolved by the kubelet after the pod labels are set at bind time
+func setEnvValueFromToPod(pod *corev1.Pod, spec exportSpec, nodeLabelKeys []string) (bool, []envConflict) {
+	targets := []envTarget{}
+
+	for _, e := range spec.expand(nodeLabelKeys) {
+		if e.env != "" {
+			targets = append(targets, envTarget{containers: e.containers, env: envVarFromField(e.env, fmt.Sprintf("metadata.labels['%s']", e.label))})
+		}
 	}
 
-	setEnvValueFromToContainers(pod.Spec.InitContainers, containers, envs)
-	setEnvValueFromToContainers(pod.Spec.Containers, containers, envs)
+	if spec.json != nil {
+		targets = append(targets, envTarget{env: envVarFromField(spec.json.env, fmt.Sprintf("metadata.annotations['%s']", spec.json.annotation))})
+	}
 
-	return true
+	return setEnvsToPod(pod, spec, targets)
 }
 
-func setEnvValueFromToContainers(items []corev1.Container, containers []string, envs map[string]string) {
-	for i := range items {
-		c := items[i]
+// setEnvsToPod sets the environment variables to the containers selected by the spec and the targets
+func setEnvsToPod(pod *corev1.Pod, spec exportSpec, targets []envTarget) (bool, []envConflict) {
+	if len(targets) == 0 {
+		return false, nil
+	}
 
-		if len(containers) == 0 || slices.Contains(containers, c.Name) {
-			for key, value := range envs {
-				updated := false
+	targets = slices.Clone(targets)
 
-				envFrom := &corev1.EnvVarSource{
-					FieldRef: &corev1.ObjectFieldSelector{
-						FieldPath: fmt.Sprintf("metadata.labels['%s']", value),
-					},
-				}
+	// Every container gets the environment variables in the same order
+	slices.SortStableFunc(targets, func(a, b envTarget) int { return strings.Compare(a.env.Name, b.env.Name) })
 
-				for j, env := range c.Env {
-					if env.Name == key {
-						items[i].Env[j].Value = ""
-						items[i].Env[j].ValueFrom = envFrom
+	containerEnvs := func(containerType string) func(c corev1.Container) []corev1.EnvVar {
+		return func(c corev1.Container) []corev1.EnvVar {
+			envs := []corev1.EnvVar{}
 
-						updated = true
-					}
+			for _, t := range targets {
+				selection := spec
+				if len(t.containers) > 0 {
+					selection.containers = t.containers
 				}
 
-				if !updated {
-					items[i].Env = append(items[i].Env, corev1.EnvVar{Name: key, ValueFrom: envFrom})
+				if selection.selectsContainer(c.Name, containerType) {
+					envs = append(envs, t.env)
 				}
 			}
+
+			return envs
 		}
 	}
+
+	sidecars, initContainers := containerEnvs(containerTypeSidecars), containerEnvs(containerTypeInit)
+	initContainerEnvs := func(c corev1.Container) []corev1.EnvVar {
+		if c.RestartPolicy != nil && *c.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+			return sidecars(c)
+		}
+
+		return initContainers(c)
+	}
+
+	written, conflicts := setEnvValueFromToContainers(pod.Spec.InitContainers, initContainerEnvs, spec.envConflict, spec.envPlacement)
+
+	names, containerConflicts := setEnvValueFromToContainers(pod.Spec.Containers, containerEnvs(containerTypeContainers), spec.envConflict, spec.envPlacement)
+	written, conflicts = append(written, names...), append(conflicts, containerConflicts...)
+
+	ephemeralContainerEnvs := containerEnvs(containerTypeEphemeral)
+
+	for i := range pod.Spec.EphemeralContainers {
+		// The ephemeral container has the same fields as the container
+		items := []corev1.Container{corev1.Container(pod.Spec.EphemeralContainers[i].EphemeralContainerCommon)}
+
+		names, containerConflicts = setEnvValueFromToContainers(items, ephemeralContainerEnvs, spec.envConflict, spec.envPlacement)
+		written, conflicts = append(written, names...), append(conflicts, containerConflicts...)
+
+		pod.Spec.EphemeralContainers[i].EphemeralContainerCommon = corev1.EphemeralContainerCommon(items[0])
+	}
+
+	if len(written) == 0 {
+		return false, conflicts
+	}
+
+	// Only the environment variables written to the containers are recorded
+	if spec.injectedEnvs != "" {
+		setAnnotationKeysToPod(pod, spec.injectedEnvs, written)
+	}
+
+	return true, conflicts
+}
+
+func envVarFromField(name, fieldPath string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{
+				FieldPath: fieldPath,
+			},
+		},
+	}
+}
+
+// envConflict is an environment variable already defined in the container
+type envConflict struct {
+	container string
+	env       string
+	action    config.EnvConflictPolicy
+}
+
+func (c envConflict) String() string {
+	switch c.action {
+	case config.EnvConflictSkip:
+		return fmt.Sprintf("container %q already defines environment variable %q, it was kept", c.container, c.env)
+	case config.EnvConflictFail:
+		return fmt.Sprintf("container %q already defines environment variable %q", c.container, c.env)
+	default:
+		return fmt.Sprintf("container %q already defines environment variable %q, it was overwritten", c.container, c.env)
+	}
+}
+
+// setEnvValueFromToContainers sets the environment variables returned by envs to the containers
+// and returns the names of the written ones. The already defined environment variables are handled by the policy,
+// the empty policy overwrites them. The environment variables with the same value are not conflicts and not written.
+// The new ones are placed by the placement.
+func setEnvValueFromToContainers(
+	items []corev1.Container,
+	envs func(c corev1.Container) []corev1.EnvVar,
+	policy config.EnvConflictPolicy,
+	placement config.EnvPlacement,
+) ([]string, []envConflict) {
+	if policy == "" {
+		policy = config.EnvConflictOverwrite
+	}
+
+	var (
+		written   []string
+		conflicts []envConflict
+	)
+
+	for i := range items {
+		c := items[i]
+		added := []corev1.EnvVar{}
+
+		for _, e := range envs(c) {
+			j := slices.IndexFunc(c.Env, func(env corev1.EnvVar) bool { return env.Name == e.Name })
+			if j < 0 {
+				added = append(added, *e.DeepCopy())
+				written = append(written, e.Name)
+
+				continue
+			}
+
+			if env := c.Env[j]; env.Value == e.Value && equality.Semantic.DeepEqual(env.ValueFrom, e.ValueFrom) {
+				continue
+			}
+
+			conflicts = append(conflicts, envConflict{container: c.Name, env: e.Name, action: policy})
+
+			if policy == config.EnvConflictOverwrite {
+				items[i].Env[j].Value = e.Value
+				items[i].Env[j].ValueFrom = e.ValueFrom.DeepCopy()
+				written = append(written, e.Name)
+			}
+		}
+
+		if len(added) > 0 {
+			items[i].Env = placeEnvs(items[i].Env, added, placement)
+		}
+	}
+
+	return written, conflicts
+}
+
+// placeEnvs returns the container environment variables with the added ones placed by the placement,
+// the empty placement is Auto
+func placeEnvs(current, added []corev1.EnvVar, placement config.EnvPlacement) []corev1.EnvVar {
+	switch placement {
+	case config.EnvPlacementAppend:
+		return slices.Concat(current, added)
+	case config.EnvPlacementPrepend:
+		return slices.Concat(added, current)
+	}
+
+	// Kubernetes expands $(VAR) only if VAR is defined before, the added variable goes before the first reference
+	placed := make([]corev1.EnvVar, 0, len(current)+len(added))
+	pending := added
+
+	for _, env := range current {
+		rest := pending[:0:0]
+
+		for _, e := range pending {
+			if strings.Contains(env.Value, "$("+e.Name+")") {
+				placed = append(placed, e)
+			} else {
+				rest = append(rest, e)
+			}
+		}
+
+		pending = rest
+		placed = append(placed, env)
+	}
+
+	return append(placed, pending...)
 }
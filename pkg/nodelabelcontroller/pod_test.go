@@ -17,10 +17,14 @@ limitations under the License.
 package nodelabelcontroller
 
 import (
+	"maps"
+	"slices"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/sergelogvinov/node-labels-exporter/pkg/config"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -34,16 +38,22 @@ func Test_annotationKeyToEnvName(t *testing.T) {
 	}{
 		{
 			name:     "valid annotation key",
-			key:      annKeyPrefix + "region",
+			key:      defaultAnnotations.keyPrefix + "region",
 			expected: "REGION",
 			ok:       true,
 		},
 		{
 			name:     "valid annotation key with hyphen",
-			key:      annKeyPrefix + "node-zone",
+			key:      defaultAnnotations.keyPrefix + "node-zone",
 			expected: "NODE_ZONE",
 			ok:       true,
 		},
+		{
+			name:     "annotation key with dot keeps the dot",
+			key:      defaultAnnotations.keyPrefix + "node.zone",
+			expected: "NODE.ZONE",
+			ok:       true,
+		},
 		{
 			name:     "invalid annotation key",
 			key:      "some.other.key",
@@ -52,13 +62,46 @@ func Test_annotationKeyToEnvName(t *testing.T) {
 		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
-			env, ok := annotationKeyToEnvName(tt.key)
+			env, ok := defaultAnnotations.annotationKeyToEnvName(tt.key)
 			assert.Equal(t, tt.expected, env)
 			assert.Equal(t, tt.ok, ok)
 		})
 	}
 }
 
+func Test_envName(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		value    string
+		expected string
+		suffix   string
+	}{
+		{
+			name:     "hyphen",
+			value:    "node-zone",
+			expected: "NODE_ZONE",
+			suffix:   "NODE_ZONE",
+		},
+		{
+			name:     "dot",
+			value:    "node.zone",
+			expected: "NODE.ZONE",
+			suffix:   "NODE_ZONE",
+		},
+		{
+			name:     "slash",
+			value:    "cpu/model",
+			expected: "CPU/MODEL",
+			suffix:   "CPU_MODEL",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, envName(tt.value))
+			assert.Equal(t, tt.suffix, suffixEnvName(tt.value))
+		})
+	}
+}
+
 func Test_getEnvsFromNode(t *testing.T) {
 	node := &corev1.Node{
 		ObjectMeta: metav1.ObjectMeta{
@@ -94,8 +137,8 @@ func Test_getEnvsFromNode(t *testing.T) {
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "pod0",
 					Annotations: map[string]string{
-						annKeyPrefix + "region":    "topology.kubernetes.io/region",
-						annKeyPrefix + "node-zone": "topology.kubernetes.io/zone",
+						defaultAnnotations.keyPrefix + "region":    "topology.kubernetes.io/region",
+						defaultAnnotations.keyPrefix + "node-zone": "topology.kubernetes.io/zone",
 					},
 				},
 			},
@@ -111,8 +154,8 @@ func Test_getEnvsFromNode(t *testing.T) {
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "pod0",
 					Annotations: map[string]string{
-						annKeyPrefix + "region": "topology.kubernetes.io/region",
-						annKeyPrefix + "test":   "test-label",
+						defaultAnnotations.keyPrefix + "region": "topology.kubernetes.io/region",
+						defaultAnnotations.keyPrefix + "test":   "test-label",
 					},
 				},
 			},
@@ -120,9 +163,34 @@ func Test_getEnvsFromNode(t *testing.T) {
 				"REGION": "region-1",
 			},
 		},
+		{
+			name: "pod with fallback chain",
+			node: node,
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "pod0",
+					Annotations: map[string]string{
+						defaultAnnotations.keyPrefix + "zone":   "failure-domain.beta.kubernetes.io/zone, topology.kubernetes.io/zone",
+						defaultAnnotations.keyPrefix + "custom": "sinextra.dev/custom,custom-label",
+					},
+				},
+			},
+			expected: map[string]string{
+				"ZONE":   "zone-1",
+				"CUSTOM": "custom-value",
+			},
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.Equal(t, tt.expected, getEnvsFromNode(tt.node, tt.pod))
+			targets, err := getEnvsFromNode(tt.node, defaultAnnotations.exportSpec(tt.pod.Annotations))
+			assert.NoError(t, err)
+
+			envs := make(map[string]string, len(targets))
+			for _, target := range targets {
+				envs[target.env.Name] = target.env.Value
+			}
+
+			assert.Equal(t, tt.expected, envs)
 		})
 	}
 }
@@ -295,8 +363,13 @@ func Test_setEnvsToPod(t *testing.T) {
 		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
+			targets := []envTarget{}
+			for name, value := range tt.envs {
+				targets = append(targets, envTarget{env: corev1.EnvVar{Name: name, Value: value}})
+			}
+
 			newPod := tt.pod.DeepCopy()
-			setEnvsToPod(newPod, tt.envs)
+			setEnvsToPod(newPod, exportSpec{}, targets)
 			assert.Equal(t, tt.expected, newPod)
 		})
 	}
@@ -326,8 +399,8 @@ func Test_setEnvValueFromToPod(t *testing.T) {
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "pod0",
 					Annotations: map[string]string{
-						annKeyPrefix + "zone":     "value1",
-						annKeyPrefix + "test-env": "value2",
+						defaultAnnotations.keyPrefix + "zone":     "value1",
+						defaultAnnotations.keyPrefix + "test-env": "value2",
 					},
 				},
 				Spec: corev1.PodSpec{
@@ -342,8 +415,9 @@ func Test_setEnvValueFromToPod(t *testing.T) {
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "pod0",
 					Annotations: map[string]string{
-						annKeyPrefix + "zone":     "value1",
-						annKeyPrefix + "test-env": "value2",
+						defaultAnnotations.keyPrefix + "zone":     "value1",
+						defaultAnnotations.keyPrefix + "test-env": "value2",
+						defaultAnnotations.injectedEnvs:           "TEST_ENV,ZONE",
 					},
 				},
 				Spec: corev1.PodSpec{
@@ -352,18 +426,18 @@ func Test_setEnvValueFromToPod(t *testing.T) {
 							Name: "container0",
 							Env: []corev1.EnvVar{
 								{
-									Name: "ZONE",
+									Name: "TEST_ENV",
 									ValueFrom: &corev1.EnvVarSource{
 										FieldRef: &corev1.ObjectFieldSelector{
-											FieldPath: "metadata.labels['value1']",
+											FieldPath: "metadata.labels['value2']",
 										},
 									},
 								},
 								{
-									Name: "TEST_ENV",
+									Name: "ZONE",
 									ValueFrom: &corev1.EnvVarSource{
 										FieldRef: &corev1.ObjectFieldSelector{
-											FieldPath: "metadata.labels['value2']",
+											FieldPath: "metadata.labels['value1']",
 										},
 									},
 								},
@@ -379,7 +453,7 @@ func Test_setEnvValueFromToPod(t *testing.T) {
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "pod0",
 					Annotations: map[string]string{
-						annKeyPrefix + "zone": "value1",
+						defaultAnnotations.keyPrefix + "zone": "value1",
 					},
 				},
 				Spec: corev1.PodSpec{
@@ -401,7 +475,8 @@ func Test_setEnvValueFromToPod(t *testing.T) {
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "pod0",
 					Annotations: map[string]string{
-						annKeyPrefix + "zone": "value1",
+						defaultAnnotations.keyPrefix + "zone": "value1",
+						defaultAnnotations.injectedEnvs:       "ZONE",
 					},
 				},
 				Spec: corev1.PodSpec{
@@ -434,8 +509,8 @@ func Test_setEnvValueFromToPod(t *testing.T) {
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "pod0",
 					Annotations: map[string]string{
-						annKeyPrefix + "zone": "value1",
-						annContainers:         "container0",
+						defaultAnnotations.keyPrefix + "zone": "value1",
+						defaultAnnotations.containers:         "container0",
 					},
 				},
 				Spec: corev1.PodSpec{
@@ -467,8 +542,9 @@ func Test_setEnvValueFromToPod(t *testing.T) {
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "pod0",
 					Annotations: map[string]string{
-						annKeyPrefix + "zone": "value1",
-						annContainers:         "container0",
+						defaultAnnotations.keyPrefix + "zone": "value1",
+						defaultAnnotations.containers:         "container0",
+						defaultAnnotations.injectedEnvs:       "ZONE",
 					},
 				},
 				Spec: corev1.PodSpec{
@@ -511,7 +587,7 @@ func Test_setEnvValueFromToPod(t *testing.T) {
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "pod0",
 					Annotations: map[string]string{
-						annKeyPrefix + "zone": "value1",
+						defaultAnnotations.keyPrefix + "zone": "value1",
 					},
 				},
 				Spec: corev1.PodSpec{
@@ -544,7 +620,8 @@ func Test_setEnvValueFromToPod(t *testing.T) {
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "pod0",
 					Annotations: map[string]string{
-						annKeyPrefix + "zone": "value1",
+						defaultAnnotations.keyPrefix + "zone": "value1",
+						defaultAnnotations.injectedEnvs:       "ZONE",
 					},
 				},
 				Spec: corev1.PodSpec{
@@ -590,11 +667,642 @@ func Test_setEnvValueFromToPod(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "pod with json labels",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "pod0",
+					Annotations: map[string]string{
+						defaultAnnotations.jsonLabels: "feature.node.kubernetes.io/*",
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name: "container0",
+						},
+					},
+				},
+			},
+			expected: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "pod0",
+					Annotations: map[string]string{
+						defaultAnnotations.jsonLabels:   "feature.node.kubernetes.io/*",
+						defaultAnnotations.injectedEnvs: "NODE_LABELS_JSON",
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name: "container0",
+							Env: []corev1.EnvVar{
+								{
+									Name: defaultJSONEnv,
+									ValueFrom: &corev1.EnvVarSource{
+										FieldRef: &corev1.ObjectFieldSelector{
+											FieldPath: "metadata.annotations['" + defaultAnnotations.nodeLabels + "']",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			newPod := tt.pod.DeepCopy()
-			setEnvValueFromToPod(newPod)
+			setEnvValueFromToPod(newPod, defaultAnnotations.exportSpec(newPod.Annotations), nil)
 			assert.Equal(t, tt.expected, newPod)
 		})
 	}
 }
+
+func Test_setLabelsToPod(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node0",
+			Labels: map[string]string{
+				"topology.kubernetes.io/region": "region-1",
+				"sinextra.dev/zone":             "zone-1",
+			},
+		},
+	}
+
+	for _, tt := range []struct {
+		name                string
+		pod                 *corev1.Pod
+		expected            map[string]string
+		policy              config.LabelConflictPolicy
+		expectedLabels      map[string]string
+		expectedAnnotations map[string]string
+		conflicts           []labelConflict
+	}{
+		{
+			name: "pod without annotations",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "pod0",
+				},
+			},
+			expected: map[string]string{},
+		},
+		{
+			name: "pod with fallback chain",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "pod0",
+					Labels: map[string]string{
+						"app": "test",
+					},
+					Annotations: map[string]string{
+						defaultAnnotations.keyPrefix + "region": "topology.kubernetes.io/region",
+						defaultAnnotations.keyPrefix + "zone":   "topology.kubernetes.io/zone,failure-domain.beta.kubernetes.io/zone,sinextra.dev/zone",
+						defaultAnnotations.keyPrefix + "rack":   "topology.kubernetes.io/rack",
+					},
+				},
+			},
+			expected: map[string]string{
+				"topology.kubernetes.io/region": "region-1",
+				"topology.kubernetes.io/zone":   "zone-1",
+			},
+			expectedLabels: map[string]string{
+				"app":                           "test",
+				"topology.kubernetes.io/region": "region-1",
+				"topology.kubernetes.io/zone":   "zone-1",
+			},
+			expectedAnnotations: map[string]string{
+				defaultAnnotations.keyPrefix + "region": "topology.kubernetes.io/region",
+				defaultAnnotations.keyPrefix + "zone":   "topology.kubernetes.io/zone,failure-domain.beta.kubernetes.io/zone,sinextra.dev/zone",
+				defaultAnnotations.keyPrefix + "rack":   "topology.kubernetes.io/rack",
+				defaultAnnotations.ownedLabels:          "topology.kubernetes.io/region,topology.kubernetes.io/zone",
+			},
+		},
+		{
+			name: "pod labels owned by another exporter instance",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "pod0",
+					Annotations: map[string]string{
+						defaultAnnotations.keyPrefix + "zone":                  "sinextra.dev/zone",
+						defaultAnnotations.ownedLabels:                         "topology.kubernetes.io/region",
+						"injector.platform.example.com/zone":                   "topology.kubernetes.io/region",
+						newAnnotationNames("platform.example.com").ownedLabels: "topology.kubernetes.io/region",
+					},
+				},
+			},
+			expected: map[string]string{
+				"sinextra.dev/zone": "zone-1",
+			},
+			expectedLabels: map[string]string{
+				"sinextra.dev/zone": "zone-1",
+			},
+			expectedAnnotations: map[string]string{
+				defaultAnnotations.keyPrefix + "zone":                  "sinextra.dev/zone",
+				defaultAnnotations.ownedLabels:                         "sinextra.dev/zone,topology.kubernetes.io/region",
+				"injector.platform.example.com/zone":                   "topology.kubernetes.io/region",
+				newAnnotationNames("platform.example.com").ownedLabels: "topology.kubernetes.io/region",
+			},
+		},
+		{
+			name: "pod labels set by the user",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "pod0",
+					Labels: map[string]string{
+						"topology.kubernetes.io/region": "user-region",
+						"sinextra.dev/zone":             "zone-1",
+					},
+					Annotations: map[string]string{
+						defaultAnnotations.keyPrefix + "region": "topology.kubernetes.io/region",
+						defaultAnnotations.keyPrefix + "zone":   "sinextra.dev/zone",
+					},
+				},
+			},
+			expected: map[string]string{
+				"topology.kubernetes.io/region": "region-1",
+			},
+			expectedLabels: map[string]string{
+				"topology.kubernetes.io/region": "region-1",
+				"sinextra.dev/zone":             "zone-1",
+			},
+			expectedAnnotations: map[string]string{
+				defaultAnnotations.keyPrefix + "region": "topology.kubernetes.io/region",
+				defaultAnnotations.keyPrefix + "zone":   "sinextra.dev/zone",
+				defaultAnnotations.ownedLabels:          "topology.kubernetes.io/region",
+			},
+			conflicts: []labelConflict{{label: "topology.kubernetes.io/region", action: config.LabelConflictOverwrite}},
+		},
+		{
+			name:   "pod labels set by the user with keep policy",
+			policy: config.LabelConflictKeep,
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "pod0",
+					Labels: map[string]string{
+						"topology.kubernetes.io/region": "user-region",
+						"sinextra.dev/zone":             "old-zone",
+					},
+					Annotations: map[string]string{
+						defaultAnnotations.keyPrefix + "region": "topology.kubernetes.io/region",
+						defaultAnnotations.keyPrefix + "zone":   "sinextra.dev/zone",
+						defaultAnnotations.ownedLabels:          "sinextra.dev/zone",
+					},
+				},
+			},
+			expected: map[string]string{
+				"sinextra.dev/zone": "zone-1",
+			},
+			expectedLabels: map[string]string{
+				"topology.kubernetes.io/region": "user-region",
+				"sinextra.dev/zone":             "zone-1",
+			},
+			expectedAnnotations: map[string]string{
+				defaultAnnotations.keyPrefix + "region": "topology.kubernetes.io/region",
+				defaultAnnotations.keyPrefix + "zone":   "sinextra.dev/zone",
+				defaultAnnotations.ownedLabels:          "sinextra.dev/zone",
+			},
+			conflicts: []labelConflict{{label: "topology.kubernetes.io/region", action: config.LabelConflictKeep}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			newPod := tt.pod.DeepCopy()
+
+			spec := defaultAnnotations.exportSpec(newPod.Annotations)
+			spec.labelConflict = tt.policy
+
+			labels, conflicts := setLabelsToPod(node, newPod, spec)
+			assert.Equal(t, tt.expected, labels)
+			assert.Equal(t, tt.conflicts, conflicts)
+			assert.Equal(t, tt.expectedLabels, newPod.Labels)
+			assert.Equal(t, tt.expectedAnnotations, newPod.Annotations)
+		})
+	}
+}
+
+func Test_setLabelsJSONToPod(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node0",
+			Labels: map[string]string{
+				"topology.kubernetes.io/region": "region-1",
+				"topology.kubernetes.io/zone":   "zone-1",
+			},
+		},
+	}
+
+	for _, tt := range []struct {
+		name                string
+		pod                 *corev1.Pod
+		expected            string
+		expectedAnnotations map[string]string
+	}{
+		{
+			name: "pod without json labels",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "pod0",
+				},
+			},
+		},
+		{
+			name: "pod with json labels",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "pod0",
+					Annotations: map[string]string{
+						defaultAnnotations.jsonLabels: "topology.kubernetes.io/*",
+					},
+				},
+			},
+			expected: `{"topology.kubernetes.io/region":"region-1","topology.kubernetes.io/zone":"zone-1"}`,
+			expectedAnnotations: map[string]string{
+				defaultAnnotations.jsonLabels: "topology.kubernetes.io/*",
+				defaultAnnotations.nodeLabels: `{"topology.kubernetes.io/region":"region-1","topology.kubernetes.io/zone":"zone-1"}`,
+			},
+		},
+		{
+			name: "pod with the same json labels",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "pod0",
+					Annotations: map[string]string{
+						defaultAnnotations.jsonLabels: "topology.kubernetes.io/zone",
+						defaultAnnotations.nodeLabels: `{"topology.kubernetes.io/zone":"zone-1"}`,
+					},
+				},
+			},
+			expectedAnnotations: map[string]string{
+				defaultAnnotations.jsonLabels: "topology.kubernetes.io/zone",
+				defaultAnnotations.nodeLabels: `{"topology.kubernetes.io/zone":"zone-1"}`,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			newPod := tt.pod.DeepCopy()
+
+			doc, err := setLabelsJSONToPod(node, newPod, defaultAnnotations.exportSpec(newPod.Annotations))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, doc)
+			assert.Equal(t, tt.expectedAnnotations, newPod.Annotations)
+		})
+	}
+}
+
+func Test_setEnvValueFromToContainers(t *testing.T) {
+	zone := envVarFromField("ZONE", "metadata.labels['topology.kubernetes.io/zone']")
+	secret := corev1.EnvVar{
+		Name: "ZONE",
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "secret"},
+				Key:                  "zone",
+			},
+		},
+	}
+
+	envs := func(corev1.Container) []corev1.EnvVar { return []corev1.EnvVar{zone} }
+
+	containers := []corev1.Container{
+		{Name: "app", Env: []corev1.EnvVar{secret}},
+		{Name: "injected", Env: []corev1.EnvVar{zone}},
+		{Name: "sidecar"},
+	}
+
+	for _, tt := range []struct {
+		name      string
+		policy    config.EnvConflictPolicy
+		expected  []corev1.Container
+		written   []string
+		conflicts []envConflict
+	}{
+		{
+			name: "default policy",
+			expected: []corev1.Container{
+				{Name: "app", Env: []corev1.EnvVar{zone}},
+				{Name: "injected", Env: []corev1.EnvVar{zone}},
+				{Name: "sidecar", Env: []corev1.EnvVar{zone}},
+			},
+			written:   []string{"ZONE", "ZONE"},
+			conflicts: []envConflict{{container: "app", env: "ZONE", action: config.EnvConflictOverwrite}},
+		},
+		{
+			name:   "skip",
+			policy: config.EnvConflictSkip,
+			expected: []corev1.Container{
+				{Name: "app", Env: []corev1.EnvVar{secret}},
+				{Name: "injected", Env: []corev1.EnvVar{zone}},
+				{Name: "sidecar", Env: []corev1.EnvVar{zone}},
+			},
+			written:   []string{"ZONE"},
+			conflicts: []envConflict{{container: "app", env: "ZONE", action: config.EnvConflictSkip}},
+		},
+		{
+			name:   "fail",
+			policy: config.EnvConflictFail,
+			expected: []corev1.Container{
+				{Name: "app", Env: []corev1.EnvVar{secret}},
+				{Name: "injected", Env: []corev1.EnvVar{zone}},
+				{Name: "sidecar", Env: []corev1.EnvVar{zone}},
+			},
+			written:   []string{"ZONE"},
+			conflicts: []envConflict{{container: "app", env: "ZONE", action: config.EnvConflictFail}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			items := make([]corev1.Container, 0, len(containers))
+			for _, c := range containers {
+				items = append(items, *c.DeepCopy())
+			}
+
+			written, conflicts := setEnvValueFromToContainers(items, envs, tt.policy, "")
+			assert.Equal(t, tt.written, written)
+			assert.Equal(t, tt.conflicts, conflicts)
+			assert.Equal(t, tt.expected, items)
+		})
+	}
+}
+
+func Test_podLabelConflicts(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pod0",
+			Labels: map[string]string{
+				"app":                         "test",
+				"topology.kubernetes.io/zone": "zone-1",
+				"sinextra.dev/rack":           "rack-1",
+			},
+			Annotations: map[string]string{
+				defaultAnnotations.keyPrefix + "zone":   "topology.kubernetes.io/zone",
+				defaultAnnotations.keyPrefix + "rack":   "sinextra.dev/rack",
+				defaultAnnotations.keyPrefix + "region": "topology.kubernetes.io/region",
+				defaultAnnotations.ownedLabels:          "sinextra.dev/rack",
+			},
+		},
+	}
+
+	spec := defaultAnnotations.exportSpec(pod.Annotations)
+	spec.labelConflict = config.LabelConflictFail
+
+	assert.Equal(t, []labelConflict{{label: "topology.kubernetes.io/zone", action: config.LabelConflictFail}}, podLabelConflicts(pod, spec, nil))
+}
+
+func Test_podLabelMapping(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node0",
+			Labels: map[string]string{
+				"topology.kubernetes.io/zone":   "zone-1",
+				"topology.kubernetes.io/region": "region-1",
+				"sinextra.dev/region":           "region-2",
+			},
+		},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pod0",
+			Annotations: map[string]string{
+				defaultAnnotations.keyPrefix + "zone":          "topology.kubernetes.io/zone",
+				defaultAnnotations.keyPrefix + "region":        "topology.kubernetes.io/region",
+				defaultAnnotations.keyPrefix + "vendor-region": "sinextra.dev/region",
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app"}},
+		},
+	}
+
+	spec := defaultAnnotations.exportSpec(pod.Annotations)
+	spec.podLabel = func(key string) string {
+		return config.PodLabelKey(key, map[string]string{"topology.kubernetes.io/zone": "sinextra.dev/zone"}, "node.sinextra.dev/")
+	}
+
+	updated, _ := setEnvValueFromToPod(pod, spec, nil)
+	assert.True(t, updated)
+	assert.Equal(t, []corev1.EnvVar{
+		envVarFromField("REGION", "metadata.labels['node.sinextra.dev/region']"),
+		envVarFromField("ZONE", "metadata.labels['sinextra.dev/zone']"),
+	}, pod.Spec.Containers[0].Env)
+
+	labels, _ := setLabelsToPod(node, pod, spec)
+	assert.Equal(t, map[string]string{
+		"node.sinextra.dev/region": "region-1",
+		"sinextra.dev/zone":        "zone-1",
+	}, labels)
+	assert.Equal(t, labels, pod.Labels)
+}
+
+func Test_setEnvValueFromToPodContainerSelection(t *testing.T) {
+	always := corev1.ContainerRestartPolicyAlways
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pod0",
+		},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				{Name: "init"},
+				{Name: "log-shipper", RestartPolicy: &always},
+			},
+			Containers: []corev1.Container{
+				{Name: "app"},
+				{Name: "app-worker"},
+				{Name: "istio-proxy"},
+			},
+			EphemeralContainers: []corev1.EphemeralContainer{
+				{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger"}},
+			},
+		},
+	}
+
+	for _, tt := range []struct {
+		name        string
+		annotations map[string]string
+		expected    []string
+	}{
+		{
+			name:        "all containers",
+			annotations: map[string]string{},
+			expected:    []string{"init", "log-shipper", "app", "app-worker", "istio-proxy", "debugger"},
+		},
+		{
+			name: "glob pattern",
+			annotations: map[string]string{
+				defaultAnnotations.containers: "app*,log-*",
+			},
+			expected: []string{"log-shipper", "app", "app-worker"},
+		},
+		{
+			name: "exclusion",
+			annotations: map[string]string{
+				defaultAnnotations.excludeContainers: "istio-*,init",
+			},
+			expected: []string{"log-shipper", "app", "app-worker", "debugger"},
+		},
+		{
+			name: "container types",
+			annotations: map[string]string{
+				defaultAnnotations.containerTypes:    "containers,sidecars",
+				defaultAnnotations.excludeContainers: "app-worker",
+			},
+			expected: []string{"log-shipper", "app", "istio-proxy"},
+		},
+		{
+			name: "ephemeral containers only",
+			annotations: map[string]string{
+				defaultAnnotations.containerTypes: "ephemeral",
+			},
+			expected: []string{"debugger"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			newPod := pod.DeepCopy()
+
+			annotations := map[string]string{defaultAnnotations.keyPrefix + "zone": "topology.kubernetes.io/zone"}
+			maps.Copy(annotations, tt.annotations)
+
+			setEnvValueFromToPod(newPod, defaultAnnotations.exportSpec(annotations), nil)
+
+			injected := []string{}
+
+			for _, c := range slices.Concat(newPod.Spec.InitContainers, newPod.Spec.Containers) {
+				if len(c.Env) > 0 {
+					injected = append(injected, c.Name)
+				}
+			}
+
+			for _, c := range newPod.Spec.EphemeralContainers {
+				if len(c.Env) > 0 {
+					injected = append(injected, c.Name)
+				}
+			}
+
+			assert.Equal(t, tt.expected, injected)
+		})
+	}
+}
+
+func Test_placeEnvs(t *testing.T) {
+	region := envVarFromField("REGION", "metadata.labels['topology.kubernetes.io/region']")
+	zone := envVarFromField("ZONE", "metadata.labels['topology.kubernetes.io/zone']")
+	peer := corev1.EnvVar{Name: "PEER_URL", Value: "http://svc-$(ZONE)"}
+	debug := corev1.EnvVar{Name: "DEBUG", Value: "true"}
+
+	for _, tt := range []struct {
+		name      string
+		placement config.EnvPlacement
+		expected  []corev1.EnvVar
+	}{
+		{
+			name:     "auto",
+			expected: []corev1.EnvVar{debug, zone, peer, region},
+		},
+		{
+			name:      "append",
+			placement: config.EnvPlacementAppend,
+			expected:  []corev1.EnvVar{debug, peer, region, zone},
+		},
+		{
+			name:      "prepend",
+			placement: config.EnvPlacementPrepend,
+			expected:  []corev1.EnvVar{region, zone, debug, peer},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			current := []corev1.EnvVar{debug, peer}
+
+			assert.Equal(t, tt.expected, placeEnvs(current, []corev1.EnvVar{region, zone}, tt.placement))
+			assert.Equal(t, []corev1.EnvVar{debug, peer}, current)
+		})
+	}
+}
+
+func Test_setEnvValueFromToPodSorted(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pod0",
+			Annotations: map[string]string{
+				defaultAnnotations.keyPrefix + "zone":   "topology.kubernetes.io/zone",
+				defaultAnnotations.keyPrefix + "region": "topology.kubernetes.io/region",
+				defaultAnnotations.jsonLabels:           "topology.kubernetes.io/*",
+				defaultAnnotations.envPlacement:         "Prepend",
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "app", Env: []corev1.EnvVar{{Name: "PEER_URL", Value: "http://svc-$(ZONE)"}}},
+				{Name: "sidecar"},
+			},
+		},
+	}
+
+	spec := defaultAnnotations.exportSpec(pod.Annotations)
+	spec.envPlacement = config.EnvPlacement(pod.Annotations[defaultAnnotations.envPlacement])
+	spec.json.annotation = defaultAnnotations.nodeLabels
+
+	updated, _ := setEnvValueFromToPod(pod, spec, nil)
+	assert.True(t, updated)
+
+	names := func(envs []corev1.EnvVar) []string {
+		result := []string{}
+		for _, e := range envs {
+			result = append(result, e.Name)
+		}
+
+		return result
+	}
+
+	assert.Equal(t, []string{"NODE_LABELS_JSON", "REGION", "ZONE", "PEER_URL"}, names(pod.Spec.Containers[0].Env))
+	assert.Equal(t, []string{"NODE_LABELS_JSON", "REGION", "ZONE"}, names(pod.Spec.Containers[1].Env))
+}
+
+func Test_setEnvValueFromToPodIdempotent(t *testing.T) {
+	always := corev1.ContainerRestartPolicyAlways
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pod0",
+			Annotations: map[string]string{
+				defaultAnnotations.keyPrefix + "zone":   "topology.kubernetes.io/zone",
+				defaultAnnotations.keyPrefix + "region": "topology.kubernetes.io/region",
+				defaultAnnotations.jsonLabels:           "topology.kubernetes.io/*",
+			},
+		},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				{Name: "log-shipper", RestartPolicy: &always},
+			},
+			Containers: []corev1.Container{
+				{Name: "app", Env: []corev1.EnvVar{{Name: "PEER_URL", Value: "http://svc-$(ZONE)"}}},
+			},
+		},
+	}
+
+	spec := defaultAnnotations.exportSpec(pod.Annotations)
+
+	updated, conflicts := setEnvValueFromToPod(pod, spec, nil)
+	assert.True(t, updated)
+	assert.Empty(t, conflicts)
+	assert.Equal(t, "NODE_LABELS_JSON,REGION,ZONE", pod.Annotations[defaultAnnotations.injectedEnvs])
+
+	expected := pod.DeepCopy()
+
+	updated, conflicts = setEnvValueFromToPod(pod, defaultAnnotations.exportSpec(pod.Annotations), nil)
+	assert.False(t, updated)
+	assert.Empty(t, conflicts)
+	assert.Equal(t, expected, pod)
+
+	// Another webhook adds a sidecar, the reinvocation injects only it
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "istio-proxy"})
+
+	updated, conflicts = setEnvValueFromToPod(pod, defaultAnnotations.exportSpec(pod.Annotations), nil)
+	assert.True(t, updated)
+	assert.Empty(t, conflicts)
+	assert.Equal(t, expected.Spec.InitContainers, pod.Spec.InitContainers)
+	assert.Equal(t, expected.Spec.Containers[0], pod.Spec.Containers[0])
+	assert.Equal(t, expected.Spec.InitContainers[0].Env, pod.Spec.Containers[1].Env)
+	assert.Equal(t, expected.Annotations, pod.Annotations)
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelabelcontroller
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/sergelogvinov/node-labels-exporter/pkg/apis/nodelabels/v1alpha1"
+
+	"sigs.k8s.io/yaml"
+)
+
+// podConfigVersion is the supported version of the structured export configuration annotation
+const podConfigVersion = "v1alpha1"
+
+// labelTransform is the transform of the node label value
+type labelTransform string
+
+const (
+	// labelTransformLower converts the value to lower case
+	labelTransformLower labelTransform = "Lower"
+	// labelTransformUpper converts the value to upper case
+	labelTransformUpper labelTransform = "Upper"
+)
+
+// IsValid reports whether the transform is known, empty transform keeps the value
+func (t labelTransform) IsValid() bool {
+	switch t {
+	case "", labelTransformLower, labelTransformUpper:
+		return true
+	}
+
+	return false
+}
+
+func (t labelTransform) apply(value string) string {
+	switch t {
+	case labelTransformLower:
+		return strings.ToLower(value)
+	case labelTransformUpper:
+		return strings.ToUpper(value)
+	}
+
+	return value
+}
+
+// podConfig is the structured export configuration of the pod annotation
+type podConfig struct {
+	// Version is the configuration version, it must be v1alpha1
+	Version string `json:"version"`
+
+	// Containers is the list of target container names or glob patterns, empty list means all containers
+	Containers []string `json:"containers,omitempty"`
+	// ExcludeContainers is the list of excluded container names or glob patterns
+	ExcludeContainers []string `json:"excludeContainers,omitempty"`
+	// ContainerTypes is the list of target container types, empty list means all types
+	ContainerTypes []string `json:"containerTypes,omitempty"`
+
+	// Exports is the list of exported node labels
+	Exports []podConfigExport `json:"exports"`
+}
+
+// podConfigExport describes a node label exported to the pod
+type podConfigExport struct {
+	v1alpha1.NodeLabelExport `json:",inline"`
+
+	// Containers is the list of target container names or glob patterns of the environment variable,
+	// it replaces the top level containers
+	Containers []string `json:"containers,omitempty"`
+	// Transform is applied to the node label value: Lower or Upper
+	Transform labelTransform `json:"transform,omitempty"`
+}
+
+// podConfigExportSpec parses and validates the structured export configuration, JSON or YAML
+func podConfigExportSpec(value string) (exportSpec, error) {
+	cfg := podConfig{}
+	if err := yaml.UnmarshalStrict([]byte(value), &cfg); err != nil { //nolint: noinlineerr
+		return exportSpec{}, fmt.Errorf("failed to parse: %v", err)
+	}
+
+	if cfg.Version != podConfigVersion {
+		return exportSpec{}, fmt.Errorf("unsupported version %q, expected %q", cfg.Version, podConfigVersion)
+	}
+
+	if len(cfg.Exports) == 0 {
+		return exportSpec{}, fmt.Errorf("exports are empty")
+	}
+
+	if err := validateContainerPatterns(slices.Concat(cfg.Containers, cfg.ExcludeContainers)); err != nil { //nolint: noinlineerr
+		return exportSpec{}, err
+	}
+
+	for _, t := range cfg.ContainerTypes {
+		if !isContainerType(t) {
+			return exportSpec{}, fmt.Errorf("unknown container type %q", t)
+		}
+	}
+
+	s := exportSpec{
+		containers:        cfg.Containers,
+		excludeContainers: cfg.ExcludeContainers,
+		containerTypes:    cfg.ContainerTypes,
+	}
+
+	for idx, export := range cfg.Exports {
+		if !export.Transform.IsValid() {
+			return exportSpec{}, fmt.Errorf("exports[%d]: unknown transform %q", idx, export.Transform)
+		}
+
+		if len(export.Containers) > 0 && export.Sink != v1alpha1.ExportSinkEnv && export.Sink != "" {
+			return exportSpec{}, fmt.Errorf("exports[%d]: containers are supported by the %s sink only", idx, v1alpha1.ExportSinkEnv)
+		}
+
+		if export.Transform != "" && export.Sink == v1alpha1.ExportSinkJSON {
+			return exportSpec{}, fmt.Errorf("exports[%d]: transform is not supported by the %s sink", idx, v1alpha1.ExportSinkJSON)
+		}
+
+		if err := validateContainerPatterns(export.Containers); err != nil { //nolint: noinlineerr
+			return exportSpec{}, fmt.Errorf("exports[%d]: %v", idx, err)
+		}
+
+		if err := s.addExport(export.NodeLabelExport, labelExport{containers: export.Containers, transform: export.Transform}); err != nil { //nolint: noinlineerr
+			return exportSpec{}, fmt.Errorf("exports[%d]: %v", idx, err)
+		}
+	}
+
+	return s, nil
+}
+
+// validateContainerPatterns returns an error for the first invalid container name glob pattern
+func validateContainerPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil { //nolint: noinlineerr
+			return fmt.Errorf("container name pattern %q is invalid: %v", pattern, err)
+		}
+	}
+
+	return nil
+}
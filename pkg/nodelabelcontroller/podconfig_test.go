@@ -0,0 +1,240 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelabelcontroller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_podConfigExportSpec(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		value    string
+		expected exportSpec
+		err      string
+	}{
+		{
+			name: "yaml",
+			value: `
+version: v1alpha1
+containers: ["app", "worker-*"]
+containerTypes: ["containers"]
+exports:
+  - keys: ["topology.kubernetes.io/zone"]
+    env: ZONE
+    containers: ["app"]
+  - keys: ["sinextra.dev/rack"]
+    env: Rack
+    containers: ["worker-*"]
+    transform: Upper
+  - keys: ["topology.kubernetes.io/region"]
+    sink: Label
+    transform: Lower
+  - keys: ["node.sinextra.dev/*"]
+    sink: JSON
+`,
+			expected: exportSpec{
+				containers:     []string{"app", "worker-*"},
+				containerTypes: []string{"containers"},
+				exports: []labelExport{
+					{env: "ZONE", label: "topology.kubernetes.io/zone", keys: []string{"topology.kubernetes.io/zone"}, containers: []string{"app"}},
+					{env: "Rack", label: "sinextra.dev/rack", keys: []string{"sinextra.dev/rack"}, containers: []string{"worker-*"}, transform: labelTransformUpper},
+					{label: "topology.kubernetes.io/region", keys: []string{"topology.kubernetes.io/region"}, transform: labelTransformLower},
+				},
+				json: &jsonExport{env: defaultJSONEnv, prefixes: []string{"node.sinextra.dev/"}},
+			},
+		},
+		{
+			name:  "json",
+			value: `{"version":"v1alpha1","exports":[{"keys":["feature.node.kubernetes.io/*"],"env":"FEATURE"}]}`,
+			expected: exportSpec{
+				exports: []labelExport{
+					{env: "FEATURE", prefix: "feature.node.kubernetes.io/"},
+				},
+			},
+		},
+		{
+			name:  "invalid document",
+			value: `{"version":"v1alpha1","exports":[]`,
+			err:   "failed to parse",
+		},
+		{
+			name:  "unknown field",
+			value: `{"version":"v1alpha1","export":[]}`,
+			err:   "failed to parse",
+		},
+		{
+			name:  "unsupported version",
+			value: `{"version":"v2","exports":[{"keys":["topology.kubernetes.io/zone"],"env":"ZONE"}]}`,
+			err:   `unsupported version "v2", expected "v1alpha1"`,
+		},
+		{
+			name:  "empty exports",
+			value: `{"version":"v1alpha1"}`,
+			err:   "exports are empty",
+		},
+		{
+			name:  "unknown container type",
+			value: `{"version":"v1alpha1","containerTypes":["pods"],"exports":[{"keys":["topology.kubernetes.io/zone"],"env":"ZONE"}]}`,
+			err:   `unknown container type "pods"`,
+		},
+		{
+			name:  "invalid container pattern",
+			value: `{"version":"v1alpha1","exports":[{"keys":["topology.kubernetes.io/zone"],"env":"ZONE","containers":["app["]}]}`,
+			err:   `exports[0]: container name pattern "app[" is invalid`,
+		},
+		{
+			name:  "unknown transform",
+			value: `{"version":"v1alpha1","exports":[{"keys":["topology.kubernetes.io/zone"],"env":"ZONE","transform":"Title"}]}`,
+			err:   `exports[0]: unknown transform "Title"`,
+		},
+		{
+			name:  "containers of label sink",
+			value: `{"version":"v1alpha1","exports":[{"keys":["topology.kubernetes.io/zone"],"sink":"Label","containers":["app"]}]}`,
+			err:   "exports[0]: containers are supported by the Env sink only",
+		},
+		{
+			name:  "transform of JSON sink",
+			value: `{"version":"v1alpha1","exports":[{"keys":["topology.kubernetes.io/zone"],"sink":"JSON","transform":"Lower"}]}`,
+			err:   "exports[0]: transform is not supported by the JSON sink",
+		},
+		{
+			name:  "env is required",
+			value: `{"version":"v1alpha1","exports":[{"keys":["topology.kubernetes.io/zone"]}]}`,
+			err:   "exports[0]: env is required for the Env sink",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := podConfigExportSpec(tt.value)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, spec)
+		})
+	}
+}
+
+func Test_exportSpecPodConfig(t *testing.T) {
+	annotations := map[string]string{
+		defaultAnnotations.containers:           "app",
+		defaultAnnotations.keyPrefix + "zone":   "topology.kubernetes.io/zone",
+		defaultAnnotations.keyPrefix + "region": "topology.kubernetes.io/region",
+		defaultAnnotations.config:               `{"version":"v1alpha1","exports":[{"keys":["sinextra.dev/zone"],"env":"ZONE"}]}`,
+	}
+
+	spec := defaultAnnotations.exportSpec(annotations)
+
+	assert.NoError(t, spec.err)
+	assert.Equal(t, []string{"app"}, spec.containers)
+	assert.Equal(t, []labelExport{
+		{env: "ZONE", label: "sinextra.dev/zone", keys: []string{"sinextra.dev/zone"}},
+		{env: "REGION", label: "topology.kubernetes.io/region", keys: []string{"topology.kubernetes.io/region"}},
+	}, spec.exports)
+
+	annotations[defaultAnnotations.config] = `{"version":"v1alpha1"}`
+
+	spec = defaultAnnotations.exportSpec(annotations)
+
+	assert.EqualError(t, spec.err, `annotation "node-labels-exporter.sinextra.dev/config" is invalid: exports are empty`)
+	assert.Len(t, spec.exports, 2)
+}
+
+func Test_setEnvValueFromToPodPerContainer(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pod0",
+			Annotations: map[string]string{
+				defaultAnnotations.config: `
+version: v1alpha1
+exports:
+  - keys: ["topology.kubernetes.io/zone"]
+    env: ZONE
+    containers: ["a"]
+  - keys: ["sinextra.dev/rack"]
+    env: RACK
+    containers: ["b"]
+  - keys: ["kubernetes.io/hostname"]
+    env: HOSTNAME
+    containers: ["b"]
+`,
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "a"},
+				{Name: "b"},
+			},
+		},
+	}
+
+	spec := defaultAnnotations.exportSpec(pod.Annotations)
+	assert.NoError(t, spec.err)
+
+	updated, conflicts := setEnvValueFromToPod(pod, spec, nil)
+	assert.True(t, updated)
+	assert.Empty(t, conflicts)
+
+	assert.Equal(t, []corev1.EnvVar{
+		envVarFromField("ZONE", "metadata.labels['topology.kubernetes.io/zone']"),
+	}, pod.Spec.Containers[0].Env)
+	assert.Equal(t, []corev1.EnvVar{
+		envVarFromField("HOSTNAME", "metadata.labels['kubernetes.io/hostname']"),
+		envVarFromField("RACK", "metadata.labels['sinextra.dev/rack']"),
+	}, pod.Spec.Containers[1].Env)
+}
+
+func Test_setLabelsToPodTransform(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node0",
+			Labels: map[string]string{
+				"topology.kubernetes.io/zone":   "Zone-A",
+				"topology.kubernetes.io/region": "region-1",
+			},
+		},
+	}
+
+	spec, err := podConfigExportSpec(`
+version: v1alpha1
+exports:
+  - keys: ["topology.kubernetes.io/zone"]
+    env: ZONE
+    transform: Lower
+  - keys: ["topology.kubernetes.io/region"]
+    env: REGION
+    transform: Upper
+`)
+	assert.NoError(t, err)
+
+	pod := &corev1.Pod{}
+
+	labels, conflicts := setLabelsToPod(node, pod, spec)
+	assert.Empty(t, conflicts)
+	assert.Equal(t, map[string]string{
+		"topology.kubernetes.io/zone":   "zone-a",
+		"topology.kubernetes.io/region": "REGION-1",
+	}, labels)
+}
@@ -0,0 +1,287 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelabelcontroller
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sergelogvinov/node-labels-exporter/pkg/apis/nodelabels/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PolicyStatusUpdatePeriod is the period of the export policy status updates
+const PolicyStatusUpdatePeriod = 30 * time.Second
+
+// policyExportSpec returns the export spec defined by the policy
+func policyExportSpec(spec v1alpha1.NodeLabelExportPolicySpec) (exportSpec, error) {
+	s := exportSpec{containers: spec.Containers}
+
+	for idx, export := range spec.Exports {
+		if export.Sink != v1alpha1.ExportSinkJSON {
+			export.Env = envName(export.Env)
+		}
+
+		if export.Env != "" && !isEnvName(export.Env) {
+			return exportSpec{}, fmt.Errorf("exports[%d]: environment variable name %q must consist of letters, digits and '_' and must not start with a digit", idx, export.Env)
+		}
+
+		if err := s.addExport(export, labelExport{}); err != nil { //nolint: noinlineerr
+			return exportSpec{}, fmt.Errorf("exports[%d]: %v", idx, err)
+		}
+	}
+
+	return s, nil
+}
+
+// policyMatches reports whether the policy selects the pod, the namespace selector is ignored if ns is nil
+func policyMatches(spec v1alpha1.NodeLabelExportPolicySpec, ns *corev1.Namespace, pod *corev1.Pod) (bool, error) {
+	if ns != nil && spec.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(spec.NamespaceSelector)
+		if err != nil {
+			return false, fmt.Errorf("invalid namespaceSelector: %v", err)
+		}
+
+		if !selector.Matches(labels.Set(ns.Labels)) {
+			return false, nil
+		}
+	}
+
+	if spec.PodSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(spec.PodSelector)
+		if err != nil {
+			return false, fmt.Errorf("invalid podSelector: %v", err)
+		}
+
+		if !selector.Matches(labels.Set(pod.Labels)) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// policyStatus collects the export policy statistics, they are flushed to the policy status periodically
+type policyStatus struct {
+	mu sync.Mutex
+
+	matched map[types.NamespacedName]int64
+	errors  map[types.NamespacedName]string
+}
+
+func newPolicyStatus() *policyStatus {
+	return &policyStatus{
+		matched: make(map[types.NamespacedName]int64),
+		errors:  make(map[types.NamespacedName]string),
+	}
+}
+
+func (p *policyStatus) match(name types.NamespacedName) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.matched[name]++
+}
+
+func (p *policyStatus) setError(name types.NamespacedName, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err != nil {
+		p.errors[name] = err.Error()
+	} else {
+		p.errors[name] = ""
+	}
+}
+
+// flush returns the collected statistics and resets them
+func (p *policyStatus) flush() (map[types.NamespacedName]int64, map[types.NamespacedName]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	matched, errors := p.matched, p.errors
+	p.matched = make(map[types.NamespacedName]int64)
+	p.errors = make(map[types.NamespacedName]string)
+
+	return matched, errors
+}
+
+// policySpec returns the export spec of all export policies selecting the pod.
+// The namespaced policies win over the cluster-wide ones, the policies of the same kind are applied in name order.
+// The namespace is nil if it is unknown, the cluster-wide policies with the namespace selector are skipped then.
+func (i *NodeLabelsEnvInjector) policySpec(ctx context.Context, namespace string, ns *corev1.Namespace, pod *corev1.Pod, created bool) exportSpec {
+	spec := exportSpec{}
+
+	if i.policies == nil {
+		return spec
+	}
+
+	namespaced := &v1alpha1.NamespacedNodeLabelExportPolicyList{}
+	if err := i.policies.List(ctx, namespaced, client.InNamespace(namespace)); err != nil { //nolint: noinlineerr
+		i.log.Error(err, "Failed to list namespaced export policies", "namespace", namespace)
+	}
+
+	slices.SortFunc(namespaced.Items, func(a, b v1alpha1.NamespacedNodeLabelExportPolicy) int { return strings.Compare(a.Name, b.Name) })
+
+	for _, policy := range namespaced.Items {
+		spec = spec.withDefaults(i.evaluatePolicy(types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name}, policy.Spec, nil, pod, created))
+	}
+
+	cluster := &v1alpha1.NodeLabelExportPolicyList{}
+	if err := i.policies.List(ctx, cluster); err != nil { //nolint: noinlineerr
+		i.log.Error(err, "Failed to list export policies")
+	}
+
+	slices.SortFunc(cluster.Items, func(a, b v1alpha1.NodeLabelExportPolicy) int { return strings.Compare(a.Name, b.Name) })
+
+	for _, policy := range cluster.Items {
+		if ns == nil && policy.Spec.NamespaceSelector != nil {
+			i.log.Info("Namespace is unknown, export policy with namespace selector is skipped", "namespace", namespace, "policy", policy.Name)
+
+			continue
+		}
+
+		spec = spec.withDefaults(i.evaluatePolicy(types.NamespacedName{Name: policy.Name}, policy.Spec, ns, pod, created))
+	}
+
+	return spec
+}
+
+func (i *NodeLabelsEnvInjector) evaluatePolicy(
+	name types.NamespacedName,
+	policy v1alpha1.NodeLabelExportPolicySpec,
+	ns *corev1.Namespace,
+	pod *corev1.Pod,
+	created bool,
+) exportSpec {
+	spec, err := policyExportSpec(policy)
+	if err != nil {
+		i.log.Error(err, "Invalid export policy", "policy", name)
+		i.policyStatus.setError(name, err)
+
+		return exportSpec{}
+	}
+
+	ok, err := policyMatches(policy, ns, pod)
+	i.policyStatus.setError(name, err)
+
+	if err != nil {
+		i.log.Error(err, "Invalid export policy", "policy", name)
+
+		return exportSpec{}
+	}
+
+	if !ok {
+		return exportSpec{}
+	}
+
+	if created {
+		i.policyStatus.match(name)
+	}
+
+	return spec
+}
+
+// RunPolicyStatusUpdater periodically updates the export policy status until the context is done
+func (i *NodeLabelsEnvInjector) RunPolicyStatusUpdater(ctx context.Context) error {
+	if i.policies == nil {
+		return nil
+	}
+
+	ticker := time.NewTicker(PolicyStatusUpdatePeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			matched, errors := i.policyStatus.flush()
+
+			names := slices.Collect(maps.Keys(matched))
+			for name := range errors {
+				if _, ok := matched[name]; !ok {
+					names = append(names, name)
+				}
+			}
+
+			for _, name := range names {
+				msg, validated := errors[name]
+
+				if err := i.updatePolicyStatus(ctx, name, matched[name], msg, validated); err != nil { //nolint: noinlineerr
+					i.log.Error(err, "Failed to update export policy status", "policy", name)
+				}
+			}
+		}
+	}
+}
+
+func (i *NodeLabelsEnvInjector) updatePolicyStatus(ctx context.Context, name types.NamespacedName, matched int64, msg string, validated bool) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var (
+			obj    client.Object
+			status *v1alpha1.NodeLabelExportPolicyStatus
+		)
+
+		if name.Namespace == "" {
+			policy := &v1alpha1.NodeLabelExportPolicy{}
+			obj, status = policy, &policy.Status
+		} else {
+			policy := &v1alpha1.NamespacedNodeLabelExportPolicy{}
+			obj, status = policy, &policy.Status
+		}
+
+		if err := i.policies.Get(ctx, name, obj); err != nil { //nolint: noinlineerr
+			return client.IgnoreNotFound(err)
+		}
+
+		status.MatchedPods += matched
+
+		if validated {
+			condition := metav1.Condition{
+				Type:               v1alpha1.ConditionReady,
+				Status:             metav1.ConditionTrue,
+				Reason:             "Valid",
+				Message:            "Export policy is valid",
+				ObservedGeneration: obj.GetGeneration(),
+			}
+
+			if msg != "" {
+				condition.Status = metav1.ConditionFalse
+				condition.Reason = "InvalidSpec"
+				condition.Message = msg
+			}
+
+			meta.SetStatusCondition(&status.Conditions, condition)
+		}
+
+		return i.policies.Status().Update(ctx, obj)
+	})
+}
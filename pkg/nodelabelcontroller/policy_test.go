@@ -0,0 +1,255 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelabelcontroller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sergelogvinov/node-labels-exporter/pkg/apis/nodelabels/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_policyExportSpec(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		spec     v1alpha1.NodeLabelExportPolicySpec
+		expected exportSpec
+		err      string
+	}{
+		{
+			name: "all sinks",
+			spec: v1alpha1.NodeLabelExportPolicySpec{
+				Containers: []string{"app"},
+				Exports: []v1alpha1.NodeLabelExport{
+					{Keys: []string{"topology.kubernetes.io/zone", "sinextra.dev/zone"}, Env: "zone"},
+					{Keys: []string{"feature.node.kubernetes.io/*"}, Env: "FEATURE"},
+					{Keys: []string{"topology.kubernetes.io/region"}, Sink: v1alpha1.ExportSinkLabel},
+					{Keys: []string{"node.kubernetes.io/instance-type", "node.sinextra.dev/*"}, Sink: v1alpha1.ExportSinkJSON},
+				},
+			},
+			expected: exportSpec{
+				containers: []string{"app"},
+				exports: []labelExport{
+					{env: "ZONE", label: "topology.kubernetes.io/zone", keys: []string{"topology.kubernetes.io/zone", "sinextra.dev/zone"}},
+					{env: "FEATURE", prefix: "feature.node.kubernetes.io/"},
+					{label: "topology.kubernetes.io/region", keys: []string{"topology.kubernetes.io/region"}},
+				},
+				json: &jsonExport{
+					env:      defaultJSONEnv,
+					keys:     []string{"node.kubernetes.io/instance-type"},
+					prefixes: []string{"node.sinextra.dev/"},
+				},
+			},
+		},
+		{
+			name: "empty keys",
+			spec: v1alpha1.NodeLabelExportPolicySpec{
+				Exports: []v1alpha1.NodeLabelExport{{Keys: []string{" "}, Env: "ZONE"}},
+			},
+			err: "exports[0]: keys are empty",
+		},
+		{
+			name: "env sink without env",
+			spec: v1alpha1.NodeLabelExportPolicySpec{
+				Exports: []v1alpha1.NodeLabelExport{{Keys: []string{"topology.kubernetes.io/zone"}}},
+			},
+			err: "exports[0]: env is required for the Env sink",
+		},
+		{
+			name: "invalid env name",
+			spec: v1alpha1.NodeLabelExportPolicySpec{
+				Exports: []v1alpha1.NodeLabelExport{{Keys: []string{"topology.kubernetes.io/zone"}, Env: "my.zone"}},
+			},
+			err: `exports[0]: environment variable name "MY.ZONE" must consist of letters, digits and '_' and must not start with a digit`,
+		},
+		{
+			name: "unknown sink",
+			spec: v1alpha1.NodeLabelExportPolicySpec{
+				Exports: []v1alpha1.NodeLabelExport{{Keys: []string{"topology.kubernetes.io/zone"}, Sink: "File"}},
+			},
+			err: `exports[0]: unknown sink "File"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := policyExportSpec(tt.spec)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, spec)
+		})
+	}
+}
+
+func Test_policyMatches(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"team": "platform"}}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod0", Labels: map[string]string{"app": "db"}}}
+
+	for _, tt := range []struct {
+		name     string
+		spec     v1alpha1.NodeLabelExportPolicySpec
+		ns       *corev1.Namespace
+		expected bool
+		err      bool
+	}{
+		{
+			name:     "empty selectors",
+			ns:       ns,
+			expected: true,
+		},
+		{
+			name: "matching selectors",
+			spec: v1alpha1.NodeLabelExportPolicySpec{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "platform"}},
+				PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			},
+			ns:       ns,
+			expected: true,
+		},
+		{
+			name: "namespace selector mismatch",
+			spec: v1alpha1.NodeLabelExportPolicySpec{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "apps"}},
+			},
+			ns: ns,
+		},
+		{
+			name: "namespace selector is ignored",
+			spec: v1alpha1.NodeLabelExportPolicySpec{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "apps"}},
+			},
+			expected: true,
+		},
+		{
+			name: "invalid pod selector",
+			spec: v1alpha1.NodeLabelExportPolicySpec{
+				PodSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Unknown"}}},
+			},
+			ns:  ns,
+			err: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := policyMatches(tt.spec, tt.ns, pod)
+			assert.Equal(t, tt.err, err != nil)
+			assert.Equal(t, tt.expected, ok)
+		})
+	}
+}
+
+func Test_policySpec(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, v1alpha1.AddToScheme(scheme))
+
+	cl := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&v1alpha1.NodeLabelExportPolicy{}, &v1alpha1.NamespacedNodeLabelExportPolicy{}).
+		WithObjects(
+			&v1alpha1.NodeLabelExportPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "topology"},
+				Spec: v1alpha1.NodeLabelExportPolicySpec{
+					Exports: []v1alpha1.NodeLabelExport{
+						{Keys: []string{"topology.kubernetes.io/zone"}, Env: "ZONE"},
+						{Keys: []string{"topology.kubernetes.io/region"}, Env: "REGION"},
+					},
+				},
+			},
+			&v1alpha1.NodeLabelExportPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "invalid"},
+				Spec: v1alpha1.NodeLabelExportPolicySpec{
+					Exports: []v1alpha1.NodeLabelExport{{Keys: []string{"topology.kubernetes.io/zone"}}},
+				},
+			},
+			&v1alpha1.NodeLabelExportPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "team"},
+				Spec: v1alpha1.NodeLabelExportPolicySpec{
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+					Exports: []v1alpha1.NodeLabelExport{
+						{Keys: []string{"topology.kubernetes.io/rack"}, Env: "RACK"},
+					},
+				},
+			},
+			&v1alpha1.NamespacedNodeLabelExportPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "zone", Namespace: "default"},
+				Spec: v1alpha1.NodeLabelExportPolicySpec{
+					Containers: []string{"app"},
+					Exports: []v1alpha1.NodeLabelExport{
+						{Keys: []string{"sinextra.dev/zone"}, Env: "ZONE"},
+					},
+				},
+			},
+		).
+		Build()
+
+	i := &NodeLabelsEnvInjector{log: logr.Discard(), policies: cl, policyStatus: newPolicyStatus()}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"team": "a"}}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod0", Namespace: "default"}}
+
+	ctx := context.Background()
+
+	assert.Equal(t, exportSpec{
+		containers: []string{"app"},
+		exports: []labelExport{
+			{env: "ZONE", label: "sinextra.dev/zone", keys: []string{"sinextra.dev/zone"}},
+			{env: "RACK", label: "topology.kubernetes.io/rack", keys: []string{"topology.kubernetes.io/rack"}},
+			{env: "REGION", label: "topology.kubernetes.io/region", keys: []string{"topology.kubernetes.io/region"}},
+		},
+	}, i.policySpec(ctx, "default", ns, pod, true))
+
+	// The namespace is unknown, the policies with the namespace selector are skipped
+	assert.Equal(t, exportSpec{
+		containers: []string{"app"},
+		exports: []labelExport{
+			{env: "ZONE", label: "sinextra.dev/zone", keys: []string{"sinextra.dev/zone"}},
+			{env: "REGION", label: "topology.kubernetes.io/region", keys: []string{"topology.kubernetes.io/region"}},
+		},
+	}, i.policySpec(ctx, "default", nil, pod, false))
+
+	matched, errors := i.policyStatus.flush()
+	for name, count := range matched {
+		assert.NoError(t, i.updatePolicyStatus(ctx, name, count, errors[name], true))
+	}
+
+	assert.NoError(t, i.updatePolicyStatus(ctx, types.NamespacedName{Name: "invalid"}, 0, errors[types.NamespacedName{Name: "invalid"}], true))
+
+	policy := &v1alpha1.NodeLabelExportPolicy{}
+	assert.NoError(t, cl.Get(ctx, types.NamespacedName{Name: "topology"}, policy))
+	assert.Equal(t, int64(1), policy.Status.MatchedPods)
+	assert.Equal(t, metav1.ConditionTrue, policy.Status.Conditions[0].Status)
+
+	assert.NoError(t, cl.Get(ctx, types.NamespacedName{Name: "invalid"}, policy))
+	assert.Equal(t, int64(0), policy.Status.MatchedPods)
+	assert.Equal(t, metav1.ConditionFalse, policy.Status.Conditions[0].Status)
+	assert.Equal(t, "exports[0]: env is required for the Env sink", policy.Status.Conditions[0].Message)
+
+	namespaced := &v1alpha1.NamespacedNodeLabelExportPolicy{}
+	assert.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "default", Name: "zone"}, namespaced))
+	assert.Equal(t, int64(1), namespaced.Status.MatchedPods)
+}
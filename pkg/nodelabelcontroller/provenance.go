@@ -0,0 +1,99 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelabelcontroller
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// auditNode is the audit annotation key of the node the labels are exported from
+	auditNode = "node"
+	// auditLabels is the audit annotation key of the pod label keys written
+	auditLabels = "labels"
+	// auditAnnotations is the audit annotation key of the pod annotation keys written
+	auditAnnotations = "annotations"
+	// auditEnvs is the audit annotation key of the injected environment variable names
+	auditEnvs = "envs"
+	// auditVersion is the audit annotation key of the exporter version
+	auditVersion = "version"
+)
+
+// provenance describes where the exported values of the pod came from
+type provenance struct {
+	// Node is the name of the node the labels are exported from
+	Node string `json:"node"`
+	// Version is the exporter version
+	Version string `json:"version"`
+	// Timestamp is the time of the export in RFC 3339 format
+	Timestamp string `json:"timestamp"`
+	// Labels is the sorted list of the pod label keys written
+	Labels []string `json:"labels,omitempty"`
+	// Annotations is the sorted list of the pod annotation keys written
+	Annotations []string `json:"annotations,omitempty"`
+}
+
+func newProvenance(node, version string, now time.Time, labels map[string]string, annotations ...string) provenance {
+	return provenance{
+		Node:        node,
+		Version:     version,
+		Timestamp:   now.UTC().Format(time.RFC3339),
+		Labels:      slices.Sorted(maps.Keys(labels)),
+		Annotations: slices.Compact(slices.Sorted(slices.Values(annotations))),
+	}
+}
+
+// setProvenanceToPod stores the provenance document in the pod annotation
+func setProvenanceToPod(pod *corev1.Pod, annotation string, p provenance) error {
+	doc, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+
+	pod.Annotations[annotation] = string(doc)
+
+	return nil
+}
+
+// auditAnnotations returns the admission audit annotations of the provenance,
+// the API server prefixes the keys with the webhook name
+func (p provenance) auditAnnotations() map[string]string {
+	audit := map[string]string{
+		auditNode:    p.Node,
+		auditVersion: p.Version,
+	}
+
+	if len(p.Labels) > 0 {
+		audit[auditLabels] = strings.Join(p.Labels, ",")
+	}
+
+	if len(p.Annotations) > 0 {
+		audit[auditAnnotations] = strings.Join(p.Annotations, ",")
+	}
+
+	return audit
+}